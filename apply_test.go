@@ -0,0 +1,300 @@
+// Fail: apply_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// fakeZoneRecord on üks fakeZoneAPI kirje
+type fakeZoneRecord struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Destination string `json:"destination"`
+	Modify      bool   `json:"modify"`
+	Delete      bool   `json:"delete"`
+}
+
+// fakeZoneAPI on olekuga Zone.ee asendus tsoonile example.ee, mis jätab meelde kirjutavad päringud
+// kujul "POST a 1.2.3.4", "PUT a/2 1.2.3.4" ja "DELETE a/2"
+type fakeZoneAPI struct {
+	mu      sync.Mutex
+	records map[string][]fakeZoneRecord // kirjetüüp (API teekonna kujul) -> kirjed
+	nextID  int
+	writes  []string
+	failGET bool // Listingud vastavad 500
+}
+
+// newFakeZoneAPI loob tsooni antud kirjetega, ID-d antakse järjest alates 1-st
+func newFakeZoneAPI(records map[string][][2]string) *fakeZoneAPI {
+	f := &fakeZoneAPI{records: make(map[string][]fakeZoneRecord)}
+	for _, rt := range managedRecordTypes {
+		for _, r := range records[rt] {
+			f.add(rt, r[0], r[1])
+		}
+	}
+	return f
+}
+
+func (f *fakeZoneAPI) add(rt, name, destination string) fakeZoneRecord {
+	f.nextID++
+	r := fakeZoneRecord{ID: fmt.Sprint(f.nextID), Name: name, Destination: destination, Modify: true, Delete: true}
+	f.records[rt] = append(f.records[rt], r)
+	return r
+}
+
+func (f *fakeZoneAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/dns" {
+		w.Write([]byte(`[{"name":"example.ee"}]`))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/dns/example.ee/"), "/")
+	rt := parts[0]
+	var body struct {
+		Name        string `json:"name"`
+		Destination string `json:"destination"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1:
+		if f.failGET {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(append([]fakeZoneRecord{}, f.records[rt]...))
+	case r.Method == http.MethodPost && len(parts) == 1:
+		f.writes = append(f.writes, fmt.Sprintf("POST %s %s", rt, body.Destination))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode([]fakeZoneRecord{f.add(rt, body.Name, body.Destination)})
+	case r.Method == http.MethodPut && len(parts) == 2:
+		f.writes = append(f.writes, fmt.Sprintf("PUT %s/%s %s", rt, parts[1], body.Destination))
+		for i, rec := range f.records[rt] {
+			if rec.ID == parts[1] {
+				f.records[rt][i].Name, f.records[rt][i].Destination = body.Name, body.Destination
+				json.NewEncoder(w).Encode([]fakeZoneRecord{f.records[rt][i]})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		f.writes = append(f.writes, fmt.Sprintf("DELETE %s/%s", rt, parts[1]))
+		f.records[rt] = slices.DeleteFunc(f.records[rt], func(rec fakeZoneRecord) bool { return rec.ID == parts[1] })
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Writes tagastab seni tehtud kirjutavad päringud
+func (f *fakeZoneAPI) Writes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.writes...)
+}
+
+// roundRobin on kahe A kirjega nimi, ID-d 1 ja 2
+var roundRobin = map[string][][2]string{"a": {{"www.example.ee", "1.1.1.1"}, {"www.example.ee", "2.2.2.2"}}}
+
+func TestApplyChangesMultiTarget(t *testing.T) {
+	www := func(targets ...string) *endpoint.Endpoint {
+		return endpoint.NewEndpoint("www.example.ee", endpoint.RecordTypeA, targets...)
+	}
+	tests := []struct {
+		name    string
+		changes *plan.Changes
+		want    []string
+	}{
+		{
+			name:    "create splits targets into records",
+			changes: &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("api.example.ee", "A", "3.3.3.3", "4.4.4.4")}},
+			want:    []string{"POST a 3.3.3.3", "POST a 4.4.4.4"},
+		},
+		{
+			name:    "replaced target becomes a PUT",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("1.1.1.1", "3.3.3.3")}},
+			want:    []string{"PUT a/2 3.3.3.3"},
+		},
+		{
+			name:    "fewer targets pair then delete leftovers",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("3.3.3.3")}},
+			want:    []string{"PUT a/1 3.3.3.3", "DELETE a/2"},
+		},
+		{
+			name:    "more targets pair then create leftovers",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("3.3.3.3", "4.4.4.4", "5.5.5.5")}},
+			want:    []string{"PUT a/1 3.3.3.3", "PUT a/2 4.4.4.4", "POST a 5.5.5.5"},
+		},
+		{
+			name:    "added target only creates",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("2.2.2.2", "1.1.1.1", "3.3.3.3")}},
+			want:    []string{"POST a 3.3.3.3"},
+		},
+		{
+			name:    "removed target only deletes",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("2.2.2.2")}},
+			want:    []string{"DELETE a/1"},
+		},
+		{
+			name:    "reordered targets change nothing",
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}, UpdateNew: []*endpoint.Endpoint{www("2.2.2.2", "1.1.1.1")}},
+			want:    nil,
+		},
+		{
+			name:    "delete removes every target",
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{www("1.1.1.1", "2.2.2.2")}},
+			want:    []string{"DELETE a/1", "DELETE a/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeZoneAPI(roundRobin)
+			provider := newTestProvider(t, api, ZoneProviderConfig{})
+			if err := provider.ApplyChanges(context.Background(), tt.changes); err != nil {
+				t.Fatalf("ApplyChanges: %v", err)
+			}
+			if got := api.Writes(); !slices.Equal(got, tt.want) {
+				t.Errorf("writes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffTargets(t *testing.T) {
+	tests := []struct {
+		recordType     string
+		old, new       []string
+		removed, added []string
+	}{
+		{"A", []string{"1.1.1.1"}, []string{"1.1.1.1"}, nil, nil},
+		{"A", []string{"1.1.1.1", "2.2.2.2"}, []string{"2.2.2.2", "3.3.3.3"}, []string{"1.1.1.1"}, []string{"3.3.3.3"}},
+		{"A", []string{"1.1.1.1", "1.1.1.1"}, []string{"2.2.2.2", "2.2.2.2"}, []string{"1.1.1.1"}, []string{"2.2.2.2"}},
+		{"CNAME", []string{"target.example.ee."}, []string{"target.example.ee"}, nil, nil},
+		{"AAAA", []string{"2001:db8::1"}, []string{"2001:0db8:0000:0000:0000:0000:0000:0001"}, nil, nil},
+		{"CAA", []string{`0 issue "ca.example"`}, []string{`0  ISSUE  "ca.example"`}, nil, nil},
+		{"TXT", []string{"value."}, []string{"value"}, []string{"value."}, []string{"value"}},
+	}
+	for _, tt := range tests {
+		removed, added := diffTargets(tt.recordType, tt.old, tt.new)
+		if !slices.Equal(removed, tt.removed) || !slices.Equal(added, tt.added) {
+			t.Errorf("diffTargets(%s, %q, %q) = %q, %q, want %q, %q", tt.recordType, tt.old, tt.new, removed, added, tt.removed, tt.added)
+		}
+	}
+}
+
+func TestWithTargetCopiesEndpoint(t *testing.T) {
+	ep := endpoint.NewEndpoint("www.example.ee", "A", "1.1.1.1", "2.2.2.2")
+	single := withTarget(ep, "2.2.2.2")
+	if !slices.Equal(single.Targets, endpoint.Targets{"2.2.2.2"}) || single.DNSName != ep.DNSName || single.RecordType != ep.RecordType {
+		t.Errorf("withTarget = %+v, want www.example.ee A 2.2.2.2", single)
+	}
+	if !slices.Equal(ep.Targets, endpoint.Targets{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("withTarget modified the original targets: %v", ep.Targets)
+	}
+}
+
+func TestApplyChangesResolveErrors(t *testing.T) {
+	duplicates := map[string][][2]string{"a": {{"www.example.ee", "1.1.1.1"}, {"www.example.ee", "1.1.1.1"}}}
+	www := func(targets ...string) *endpoint.Endpoint {
+		return endpoint.NewEndpoint("www.example.ee", endpoint.RecordTypeA, targets...)
+	}
+	tests := []struct {
+		name    string
+		records map[string][][2]string
+		failGET bool
+		changes *plan.Changes
+		wantErr string
+		want    []string
+	}{
+		{
+			name:    "update of a missing record",
+			records: roundRobin,
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("9.9.9.9")}, UpdateNew: []*endpoint.Endpoint{www("3.3.3.3")}},
+			wantErr: "no Zone.ee record found for www.example.ee A 9.9.9.9",
+		},
+		{
+			name:    "delete of a missing record",
+			records: roundRobin,
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("api.example.ee", "A", "1.1.1.1")}},
+			wantErr: "no Zone.ee record found for api.example.ee A 1.1.1.1",
+		},
+		{
+			name:    "update of a duplicated record",
+			records: duplicates,
+			changes: &plan.Changes{UpdateOld: []*endpoint.Endpoint{www("1.1.1.1")}, UpdateNew: []*endpoint.Endpoint{www("3.3.3.3")}},
+			wantErr: "ambiguous match for www.example.ee A 1.1.1.1 in zone example.ee: 2 records (IDs: 1, 2)",
+		},
+		{
+			name:    "delete of a duplicated record",
+			records: duplicates,
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{www("1.1.1.1")}},
+			wantErr: "ambiguous match",
+		},
+		{
+			name:    "listing fails",
+			records: roundRobin,
+			failGET: true,
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{www("1.1.1.1")}},
+			wantErr: "failed to list zone example.ee",
+		},
+		{
+			name:    "one failed target does not block the others",
+			records: roundRobin,
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{www("9.9.9.9", "2.2.2.2")}},
+			wantErr: "no Zone.ee record found for www.example.ee A 9.9.9.9",
+			want:    []string{"DELETE a/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeZoneAPI(tt.records)
+			api.failGET = tt.failGET
+			provider := newTestProvider(t, api, ZoneProviderConfig{})
+			err := provider.ApplyChanges(context.Background(), tt.changes)
+			var applyErr *ApplyError
+			if !errors.As(err, &applyErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want *ApplyError containing %q", err, tt.wantErr)
+			}
+			if got := api.Writes(); !slices.Equal(got, tt.want) {
+				t.Errorf("writes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyChangesResolvesIDsFromLiveListing(t *testing.T) {
+	api := newFakeZoneAPI(roundRobin)
+	provider := newTestProvider(t, api, ZoneProviderConfig{RecordsCacheMaxAge: time.Minute})
+	ctx := context.Background()
+	if _, err := provider.Records(ctx); err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+
+	// Kirje muutub väljaspool webhooki pärast Records kutset: 2.2.2.2 kustutatakse ja luuakse uue ID-ga.
+	// Cache'is on endiselt vana ID, aga ApplyChanges peab ID leidma tsooni elavast listingust.
+	api.mu.Lock()
+	api.records["a"] = api.records["a"][:1]
+	api.add("a", "www.example.ee", "2.2.2.2")
+	api.mu.Unlock()
+
+	changes := &plan.Changes{Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("www.example.ee", "A", "2.2.2.2")}}
+	if err := provider.ApplyChanges(ctx, changes); err != nil {
+		t.Fatalf("ApplyChanges: %v", err)
+	}
+	if got, want := api.Writes(), []string{"DELETE a/3"}; !slices.Equal(got, want) {
+		t.Errorf("writes = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+// Fail: auth.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook API autentimise režiimid
+const (
+	AuthModeNone   = "none"
+	AuthModeBearer = "bearer"
+	AuthModeHMAC   = "hmac"
+)
+
+// HMAC allkirja päised. Allkiri arvutatakse sõnumist "<timestamp>\n<meetod>\n<teekond>\n<keha>".
+const (
+	signatureHeader          = "X-Zoneee-Signature"
+	signatureTimestampHeader = "X-Zoneee-Timestamp"
+	signaturePrefix          = "sha256="
+)
+
+// maxSignatureSkew piirab, kui vana (või tulevikus) allkirjastatud päring võib olla, et seda ei saaks hiljem korrata
+const maxSignatureSkew = 5 * time.Minute
+
+// maxSignedBodyBytes piirab allkirja kontrolliks loetava keha suurust
+const maxSignedBodyBytes = 10 << 20
+
+// authError kirjeldab tagasi lükatud päringut, reason on mõõdiku silt
+type authError struct {
+	reason string
+	msg    string
+}
+
+func (e *authError) Error() string {
+	return e.msg
+}
+
+// secretFile loeb jagatud saladused failist ja loeb faili uuesti, kui see muutub (nt Kubernetes Secret'i
+// uuendamine), nii saab saladust vahetada ilma taaskäivituseta. Iga mittetühi rida on eraldi kehtiv
+// saladus, nii saab vahetuse ajal korraga kehtida vana ja uus saladus.
+type secretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	secrets [][]byte
+}
+
+// load tagastab kehtivad saladused. Kui fail on muutunud, loetakse see uuesti; uue sisu lugemise
+// ebaõnnestumisel kasutatakse eelmisi saladusi, kui need on olemas.
+func (f *secretFile) load() ([][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err == nil && f.secrets != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.secrets, nil
+	}
+	if err == nil {
+		var secrets [][]byte
+		secrets, err = readSecrets(f.path)
+		if err == nil {
+			f.secrets, f.modTime, f.size = secrets, info.ModTime(), info.Size()
+			return secrets, nil
+		}
+	}
+	if f.secrets != nil {
+		return f.secrets, nil
+	}
+	return nil, err
+}
+
+// readSecrets loeb faili mittetühjad read saladusteks
+func readSecrets(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook auth secret file: %w", err)
+	}
+	var secrets [][]byte
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			secrets = append(secrets, []byte(line))
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("webhook auth secret file %s is empty", path)
+	}
+	return secrets, nil
+}
+
+// WebhookAuth kontrollib webhook päringute bearer tokenit või HMAC allkirja
+type WebhookAuth struct {
+	mode   string
+	secret *secretFile
+}
+
+// NewWebhookAuth loob autentija. AuthModeNone (või tühi režiim) korral tagastatakse nil, mis lülitab
+// autentimise välja. Saladuse fail loetakse kohe, et vigane seadistus ilmneks käivitamisel.
+func NewWebhookAuth(mode, secretFilePath string) (*WebhookAuth, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "", AuthModeNone:
+		return nil, nil
+	case AuthModeBearer, AuthModeHMAC:
+	default:
+		return nil, fmt.Errorf("invalid webhook auth mode %q, expected %s, %s or %s", mode, AuthModeNone, AuthModeBearer, AuthModeHMAC)
+	}
+	if secretFilePath == "" {
+		return nil, fmt.Errorf("webhook auth mode %s requires a secret file", mode)
+	}
+	auth := &WebhookAuth{mode: mode, secret: &secretFile{path: secretFilePath}}
+	if _, err := auth.secret.load(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Mode tagastab autentimise režiimi
+func (a *WebhookAuth) Mode() string {
+	return a.mode
+}
+
+// authenticate kontrollib päringut. HMAC režiimis loetakse keha ja asendatakse koopiaga, et handler saaks seda lugeda.
+func (a *WebhookAuth) authenticate(r *http.Request) error {
+	secrets, err := a.secret.load()
+	if err != nil {
+		return &authError{reason: "error", msg: err.Error()}
+	}
+	if a.mode == AuthModeBearer {
+		return checkBearerToken(r, secrets)
+	}
+	return checkSignature(r, secrets, time.Now())
+}
+
+// checkBearerToken võrdleb Authorization päise tokenit konstantse ajaga iga kehtiva saladusega
+func checkBearerToken(r *http.Request, secrets [][]byte) error {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return &authError{reason: "missing", msg: "missing or malformed Authorization header"}
+	}
+	for _, secret := range secrets {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), secret) == 1 {
+			return nil
+		}
+	}
+	return &authError{reason: "invalid", msg: "token does not match any configured secret"}
+}
+
+// checkSignature kontrollib HMAC-SHA256 allkirja ja ajatemplit
+func checkSignature(r *http.Request, secrets [][]byte, now time.Time) error {
+	signature := r.Header.Get(signatureHeader)
+	timestamp := r.Header.Get(signatureTimestampHeader)
+	if signature == "" || timestamp == "" {
+		return &authError{reason: "missing", msg: fmt.Sprintf("missing %s or %s header", signatureHeader, signatureTimestampHeader)}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &authError{reason: "invalid", msg: fmt.Sprintf("invalid %s header", signatureTimestampHeader)}
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return &authError{reason: "expired", msg: fmt.Sprintf("signature timestamp is outside the allowed %s window", maxSignatureSkew)}
+	}
+	mac, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return &authError{reason: "invalid", msg: fmt.Sprintf("invalid %s header", signatureHeader)}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxSignedBodyBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return &authError{reason: "invalid", msg: "request body too large"}
+		}
+		return &authError{reason: "error", msg: fmt.Sprintf("failed to read request body: %v", err)}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	for _, secret := range secrets {
+		if hmac.Equal(mac, signRequest(secret, timestamp, r.Method, r.URL.Path, body)) {
+			return nil
+		}
+	}
+	return &authError{reason: "invalid", msg: "invalid request signature"}
+}
+
+// signRequest arvutab päringu HMAC-SHA256 allkirja
+func signRequest(secret []byte, timestamp, method, path string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n", timestamp, method, path)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// withAuth lükkab autentimata päringud tagasi enne handlerit. auth == nil korral autentimist ei tehta.
+// Tagasilükkamised logitakse (ilma saladusteta) ja loendatakse põhjuse järgi.
+func withAuth(auth *WebhookAuth, next http.Handler) http.Handler {
+	if auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := auth.authenticate(r)
+		if err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reason := "invalid"
+		var authErr *authError
+		if errors.As(err, &authErr) {
+			reason = authErr.reason
+		}
+		authRejectedTotal.WithLabelValues(auth.mode, reason).Inc()
+		logFrom(r.Context()).Warn("Rejecting unauthenticated request", "auth_mode", auth.mode, "reason", reason, "remote_addr", r.RemoteAddr, logKeyError, err)
+
+		if reason == "error" {
+			http.Error(w, "Internal Server Error: authentication is unavailable", http.StatusInternalServerError)
+			return
+		}
+		if auth.mode == AuthModeBearer {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="external-dns-zoneee-webhook"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
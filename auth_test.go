@@ -0,0 +1,234 @@
+// Fail: auth_test.go
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newAuthServer loob serveri, mis vastab autenditud päringule selle kehaga
+func newAuthServer(t *testing.T, mode, secrets string) (*httptest.Server, string) {
+	t.Helper()
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	writeSecretFile(t, secretPath, secrets)
+	auth, err := NewWebhookAuth(mode, secretPath)
+	if err != nil {
+		t.Fatalf("NewWebhookAuth: %v", err)
+	}
+	server := httptest.NewServer(withAuth(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})))
+	t.Cleanup(server.Close)
+	return server, secretPath
+}
+
+// writeSecretFile kirjutab saladused faili ja nihutab muutmise aega edasi, et load märkaks muudatust
+// ka siis, kui failisüsteemi ajatempli täpsus on kehv ja suurus ei muutu
+func writeSecretFile(t *testing.T, path, secrets string) {
+	t.Helper()
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil && !info.ModTime().Before(modTime) {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, []byte(secrets), 0o600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("touch secret file: %v", err)
+	}
+}
+
+// signedRequest loob allkirjastatud POST /records päringu
+func signedRequest(t *testing.T, url, secret, body string, ts time.Time) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/records", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := signRequest([]byte(secret), timestamp, http.MethodPost, "/records", []byte(body))
+	req.Header.Set(signatureHeader, signaturePrefix+hex.EncodeToString(mac))
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	return req
+}
+
+// doAuthRequest saadab päringu ja tagastab staatuse, keha ning selle põhjuse tagasilükkamiste arvu muutuse
+func doAuthRequest(t *testing.T, req *http.Request, mode, reason string) (int, string, float64) {
+	t.Helper()
+	rejected := authRejectedTotal.WithLabelValues(mode, reason)
+	before := testutil.ToFloat64(rejected)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), testutil.ToFloat64(rejected) - before
+}
+
+func TestHMACAuth(t *testing.T) {
+	server, _ := newAuthServer(t, AuthModeHMAC, "s3cr3t\n")
+	const body = `{"Create":[]}`
+
+	tests := []struct {
+		name       string
+		req        func() *http.Request
+		wantStatus int
+		reason     string
+	}{
+		{"valid signature", func() *http.Request {
+			return signedRequest(t, server.URL, "s3cr3t", body, time.Now())
+		}, http.StatusOK, "invalid"},
+		{"tampered body", func() *http.Request {
+			req := signedRequest(t, server.URL, "s3cr3t", body, time.Now())
+			req.Body = io.NopCloser(strings.NewReader(`{"Delete":[]}`))
+			req.ContentLength = int64(len(`{"Delete":[]}`))
+			return req
+		}, http.StatusUnauthorized, "invalid"},
+		{"wrong secret", func() *http.Request {
+			return signedRequest(t, server.URL, "other", body, time.Now())
+		}, http.StatusUnauthorized, "invalid"},
+		{"expired timestamp", func() *http.Request {
+			return signedRequest(t, server.URL, "s3cr3t", body, time.Now().Add(-maxSignatureSkew-time.Minute))
+		}, http.StatusUnauthorized, "expired"},
+		{"future timestamp", func() *http.Request {
+			return signedRequest(t, server.URL, "s3cr3t", body, time.Now().Add(maxSignatureSkew+time.Minute))
+		}, http.StatusUnauthorized, "expired"},
+		{"missing signature", func() *http.Request {
+			req := signedRequest(t, server.URL, "s3cr3t", body, time.Now())
+			req.Header.Del(signatureHeader)
+			return req
+		}, http.StatusUnauthorized, "missing"},
+		{"malformed signature", func() *http.Request {
+			req := signedRequest(t, server.URL, "s3cr3t", body, time.Now())
+			req.Header.Set(signatureHeader, signaturePrefix+"zz")
+			return req
+		}, http.StatusUnauthorized, "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, got, rejected := doAuthRequest(t, tt.req(), AuthModeHMAC, tt.reason)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body: %s", status, tt.wantStatus, got)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if got != body {
+					t.Errorf("handler read body %q, want %q", got, body)
+				}
+				if rejected != 0 {
+					t.Errorf("rejections recorded = %g, want 0", rejected)
+				}
+				return
+			}
+			if rejected != 1 {
+				t.Errorf("rejections{reason=%s} recorded = %g, want 1", tt.reason, rejected)
+			}
+		})
+	}
+}
+
+func TestHMACAuthSecretRotation(t *testing.T) {
+	server, secretPath := newAuthServer(t, AuthModeHMAC, "old-secret\n")
+	const body = `{}`
+	expectStatus := func(secret string, want int) {
+		t.Helper()
+		status, got, _ := doAuthRequest(t, signedRequest(t, server.URL, secret, body, time.Now()), AuthModeHMAC, "invalid")
+		if status != want {
+			t.Errorf("signed with %s: status = %d, want %d; body: %s", secret, status, want, got)
+		}
+	}
+
+	expectStatus("old-secret", http.StatusOK)
+	expectStatus("new-secret", http.StatusUnauthorized)
+
+	// Vahetuse ajal kehtivad mõlemad saladused
+	writeSecretFile(t, secretPath, "new-secret\nold-secret\n")
+	expectStatus("old-secret", http.StatusOK)
+	expectStatus("new-secret", http.StatusOK)
+
+	// Pärast vahetust kehtib ainult uus saladus
+	writeSecretFile(t, secretPath, "new-secret\n")
+	expectStatus("old-secret", http.StatusUnauthorized)
+	expectStatus("new-secret", http.StatusOK)
+
+	// Tühjaks jäänud fail ei lülita autentimist välja, kehtima jääb viimane loetud saladus
+	writeSecretFile(t, secretPath, "")
+	expectStatus("new-secret", http.StatusOK)
+	expectStatus("old-secret", http.StatusUnauthorized)
+}
+
+func TestBearerAuth(t *testing.T) {
+	server, secretPath := newAuthServer(t, AuthModeBearer, "token-1\n")
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		reason     string
+	}{
+		{"valid token", "Bearer token-1", http.StatusOK, "invalid"},
+		{"lowercase scheme", "bearer token-1", http.StatusOK, "invalid"},
+		{"wrong token", "Bearer token-2", http.StatusUnauthorized, "invalid"},
+		{"missing header", "", http.StatusUnauthorized, "missing"},
+		{"basic auth", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/records", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			status, body, rejected := doAuthRequest(t, req, AuthModeBearer, tt.reason)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body: %s", status, tt.wantStatus, body)
+			}
+			wantRejected := 0.0
+			if tt.wantStatus != http.StatusOK {
+				wantRejected = 1
+			}
+			if rejected != wantRejected {
+				t.Errorf("rejections{reason=%s} recorded = %g, want %g", tt.reason, rejected, wantRejected)
+			}
+		})
+	}
+
+	// Uus token hakkab kehtima ilma taaskäivituseta
+	writeSecretFile(t, secretPath, "token-2\n")
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/records", nil)
+	req.Header.Set("Authorization", "Bearer token-2")
+	if status, body, _ := doAuthRequest(t, req, AuthModeBearer, "invalid"); status != http.StatusOK {
+		t.Errorf("rotated token: status = %d, want 200; body: %s", status, body)
+	}
+}
+
+func TestNewWebhookAuth(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	writeSecretFile(t, secretPath, "s3cr3t\n")
+
+	if auth, err := NewWebhookAuth(AuthModeNone, ""); auth != nil || err != nil {
+		t.Errorf("mode none = %v, %v, want disabled auth", auth, err)
+	}
+	if _, err := NewWebhookAuth("digest", secretPath); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	if _, err := NewWebhookAuth(AuthModeHMAC, ""); err == nil {
+		t.Error("hmac mode without secret file accepted")
+	}
+	if _, err := NewWebhookAuth(AuthModeBearer, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing secret file accepted")
+	}
+	if auth, err := NewWebhookAuth(" HMAC ", secretPath); err != nil || auth.Mode() != AuthModeHMAC {
+		t.Errorf("mode HMAC = %v, %v, want hmac auth", auth, err)
+	}
+}
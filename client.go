@@ -4,36 +4,134 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"sigs.k8s.io/external-dns/endpoint" // Vajalik endpoint.Endpoint jaoks
 )
 
 const (
-	zoneAPIURL = "https://api.zone.eu/v2"
+	zoneAPIURL            = "https://api.zone.eu/v2" // Vaikimisi API aadress
+	defaultRequestTimeout = 30 * time.Second
 )
 
 // ZoneClient struct haldab API ühendust ja autentimist
 type ZoneClient struct {
 	httpClient *http.Client
+	baseURL    string
 	username   string
 	apiKey     string
+	retry      RetryConfig
+	limiter    *apiRateLimiter // Jagatud kõigi tsoonide vahel
+	// concurrency on tsoonide ja kirjetüüpide paralleelse lugemise töötajate arv,
+	// inflight piirab samaaegseid HTTP päringuid sama arvuga ka pesastatud paralleelsuse korral
+	concurrency int
+	inflight    chan struct{}
+}
+
+// ZoneClientOption muudab ZoneClienti seadistust loomisel
+type ZoneClientOption func(*ZoneClient)
+
+// WithBaseURL määrab API baasaadressi (nt lokaalne asendus-API CI jaoks)
+func WithBaseURL(baseURL string) ZoneClientOption {
+	return func(c *ZoneClient) {
+		if baseURL != "" {
+			c.baseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	}
+}
+
+// WithTimeout määrab ühe API päringu ajalimiidi
+func WithTimeout(timeout time.Duration) ZoneClientOption {
+	return func(c *ZoneClient) {
+		if timeout > 0 {
+			c.httpClient.Timeout = timeout
+		}
+	}
+}
+
+// WithTransport määrab HTTP transpordi (proxy, CA bundle, testide jaoks asendus jne)
+func WithTransport(rt http.RoundTripper) ZoneClientOption {
+	return func(c *ZoneClient) {
+		if rt != nil {
+			c.httpClient.Transport = rt
+		}
+	}
+}
+
+// WithConcurrency määrab, mitu Zone.ee päringut võib korraga käia (tsoonide ja kirjetüüpide lugemisel).
+// Päringute tempot piirab endiselt jagatud rate limiter.
+func WithConcurrency(n int) ZoneClientOption {
+	return func(c *ZoneClient) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
 }
 
 // NewZoneClient loob uue Zone API kliendi instantsi
-func NewZoneClient(username, apiKey string) *ZoneClient {
-	return &ZoneClient{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		username:   username,
-		apiKey:     apiKey,
+func NewZoneClient(username, apiKey string, opts ...ZoneClientOption) *ZoneClient {
+	c := &ZoneClient{
+		httpClient:  &http.Client{Timeout: defaultRequestTimeout},
+		baseURL:     zoneAPIURL,
+		retry:       DefaultRetryConfig,
+		limiter:     newAPIRateLimiter(DefaultRateLimitConfig),
+		concurrency: DefaultConcurrency,
+		username:    username,
+		apiKey:      apiKey,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.inflight = make(chan struct{}, c.concurrency)
+	return c
+}
+
+// NewZoneTransport loob HTTP transpordi valikulise proxy ja lisa-CA bundle'iga.
+// Tühja proxyURL korral kasutatakse HTTPS_PROXY/HTTP_PROXY/NO_PROXY keskkonnamuutujaid.
+// CA bundle lisatakse süsteemi sertifikaatidele, mitte ei asenda neid.
+func NewZoneTransport(proxyURL, caBundleFile string) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL '%s': %w", proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(u)
 	}
+
+	if caBundleFile != "" {
+		pem, err := os.ReadFile(caBundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", caBundleFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid PEM certificates found in CA bundle %s", caBundleFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return transport, nil
 }
 
 // basicAuth genereerib HTTP Basic Auth päise väärtuse
@@ -42,154 +140,530 @@ func (c *ZoneClient) basicAuth() string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
-// doRequest on üldine abifunktsioon API päringute tegemiseks
-func (c *ZoneClient) doRequest(ctx context.Context, method, path string, requestBody interface{}, responseTarget interface{}) error {
-	url := zoneAPIURL + path
+// RetryConfig kirjeldab korduste eelarvet ajutiste vigade (429, 5xx, võrguvead) korral
+type RetryConfig struct {
+	MaxRetries     int           // Korduste arv lisaks esimesele katsele, 0 = ei korrata
+	InitialBackoff time.Duration // Esimese korduse ooteaja ülempiir
+	MaxBackoff     time.Duration // Ühe ooteaja ülempiir, kehtib ka Retry-After päisele
+}
 
-	var reqBodyReader io.Reader
+// DefaultRetryConfig on vaikimisi korduste seadistus
+var DefaultRetryConfig = RetryConfig{
+	MaxRetries:     3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// WithRetry määrab korduste eelarve
+func WithRetry(cfg RetryConfig) ZoneClientOption {
+	return func(c *ZoneClient) {
+		if cfg.MaxRetries >= 0 {
+			c.retry = cfg
+		}
+	}
+}
+
+// WithRateLimit määrab kliendipoolse päringute tempo (RequestsPerSecond <= 0 = fikseeritud ülempiirita)
+func WithRateLimit(cfg RateLimitConfig) ZoneClientOption {
+	return func(c *ZoneClient) {
+		c.limiter = newAPIRateLimiter(cfg)
+	}
+}
+
+// errAmbiguousRequest tähendab, et mitte-idempotentne päring (POST) võis serveris õnnestuda,
+// kuigi vastust ei saadud (timeout, katkenud ühendus, 5xx). Seda ei korrata pimesi.
+var errAmbiguousRequest = errors.New("request outcome unknown")
+
+// apiResponse on ühe HTTP katse tulemus
+type apiResponse struct {
+	status     int
+	statusText string
+	header     http.Header
+	body       []byte
+}
+
+// doRequest on üldine abifunktsioon API päringute tegemiseks.
+// Idempotentseid päringuid (GET, PUT, DELETE) korratakse 429, 5xx ja võrguvigade korral
+// eksponentsiaalse ooteaja ja jitteriga, arvestades Retry-After päist.
+// POST päringut korratakse ainult 429 korral (päringut pole töödeldud); muude ajutiste vigade
+// korral tagastatakse errAmbiguousRequest ja duplikaatide vältimise otsustab kutsuja.
+// API poolt tagasi lükatud päringud tagastatakse *ZoneAPIError kujul.
+// Iga kutse (koos kordustega) on eraldi span, mis seotakse webhook päringu spaniga.
+func (c *ZoneClient) doRequest(ctx context.Context, method, path string, requestBody interface{}, responseTarget interface{}) (err error) {
+	ctx, span := startAPISpan(ctx, method, path)
+	var lastResp *apiResponse
+	attempts := 0
+	defer func() { endAPISpan(span, lastResp, attempts, err) }()
+
+	url := c.baseURL + path
+
+	var jsonData []byte
 	if requestBody != nil {
-		jsonData, err := json.Marshal(requestBody)
+		var err error
+		jsonData, err = json.Marshal(requestBody)
 		if err != nil {
 			return fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBodyReader = bytes.NewBuffer(jsonData)
-		// Logime päringu keha ainult siis, kui see pole tühi (väldime tundliku info logimist GET päringutes)
-		// Parandus: Logime alati, aga maskeerime tundliku info vajadusel hiljem. Praegu logime.
-		log.Printf("DEBUG: Request Body (%s %s): %s", method, url, string(jsonData))
+		// Keha logitakse ainult debug tasemel, saladused ja tundlikud TXT väärtused eemaldab logger
+		logFrom(ctx).Debug("Zone.ee API request body", "api_method", method, "api_url", url, "body", loggedBody(jsonData))
+	}
+
+	idempotent := method != http.MethodPost
+	recordType := metricRecordType(path)
+	for attempt := 0; ; attempt++ {
+		release, err := c.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		// Latentsus mõõdetakse ilma piiraja ooteajata, et see näitaks Zone.ee enda kiirust
+		start := time.Now()
+		resp, err := c.doAttempt(ctx, method, url, jsonData)
+		observeAPIRequest(method, recordType, resp, time.Since(start))
+		release()
+		attempts, lastResp = attempt+1, resp
+
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to execute request: %w", err)
+			}
+			err = fmt.Errorf("failed to execute request: %w", err)
+			if !idempotent {
+				return fmt.Errorf("%w: %w", errAmbiguousRequest, err)
+			}
+		case resp.status >= 200 && resp.status < 300:
+			if responseTarget != nil && len(resp.body) > 0 && resp.status != http.StatusNoContent {
+				if err := json.Unmarshal(resp.body, responseTarget); err != nil {
+					// Keha võib sisaldada tundlikke TXT väärtusi ja jõuab veateatega ka spanidesse, see logitakse ainult debug tasemel
+					return fmt.Errorf("failed to unmarshal %d byte response body into target type %T: %w", len(resp.body), responseTarget, err)
+				}
+			}
+			return nil
+		case resp.status == http.StatusTooManyRequests:
+			// Throttling: päringut pole töödeldud, seega võib korrata ka POST päringut
+			err = newZoneAPIError(method, path, resp)
+			retryAfter = parseRetryAfter(resp.header.Get("Retry-After"))
+		case resp.status >= 500:
+			err = newZoneAPIError(method, path, resp)
+			if !idempotent {
+				return fmt.Errorf("%w: %w", errAmbiguousRequest, err)
+			}
+			retryAfter = parseRetryAfter(resp.header.Get("Retry-After"))
+		default:
+			// Muud 4xx vead on püsivad, kordamine ei aita
+			return newZoneAPIError(method, path, resp)
+		}
+
+		if attempt >= c.retry.MaxRetries {
+			if attempt > 0 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+			}
+			return err
+		}
+		wait := c.backoff(ctx, attempt, retryAfter)
+		state := c.limiter.State()
+		logFrom(ctx).Warn("Zone.ee API request failed, retrying",
+			"api_method", method, "api_url", url, "attempt", attempt+1, "max_attempts", c.retry.MaxRetries+1, "wait", wait.String(),
+			"rate_limit", state.Limit, "quota_remaining", state.QuotaRemaining, "quota_limit", state.QuotaLimit, logKeyError, err)
+		if err := sleepCtx(ctx, wait); err != nil {
+			return fmt.Errorf("retry of %s %s aborted: %w", method, url, err)
+		}
+	}
+}
+
+// acquire ootab piiraja järjekorda ja vaba päringu kohta. Tagastatud release vabastab koha.
+func (c *ZoneClient) acquire(ctx context.Context) (release func(), err error) {
+	// Iga katse (ka kordus) läbib jagatud piiraja
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limiter wait aborted: %w", err)
+	}
+	select {
+	case c.inflight <- struct{}{}:
+		return func() { <-c.inflight }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free request slot aborted: %w", ctx.Err())
+	}
+}
+
+// doAttempt teeb ühe HTTP päringu ja loeb vastuse keha
+func (c *ZoneClient) doAttempt(ctx context.Context, method, url string, jsonData []byte) (*apiResponse, error) {
+	var reqBodyReader io.Reader
+	if jsonData != nil {
+		reqBodyReader = bytes.NewReader(jsonData)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBodyReader)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", c.basicAuth())
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	log.Printf("INFO: Making API request: %s %s", method, url)
+	logFrom(ctx).Debug("Making Zone.ee API request", "api_method", method, "api_url", url)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	c.limiter.Observe(ctx, resp.Header, resp.StatusCode == http.StatusTooManyRequests)
+
 	respBodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
-	log.Printf("DEBUG: Response Status (%s %s): %s", method, url, resp.Status)
-	log.Printf("DEBUG: Response Body (%s %s): %s", method, url, string(respBodyBytes))
+	logFrom(ctx).Debug("Zone.ee API response", "api_method", method, "api_url", url, "status", resp.Status, "body", loggedBody(respBodyBytes))
+
+	return &apiResponse{status: resp.StatusCode, statusText: resp.Status, header: resp.Header, body: respBodyBytes}, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("api request failed with status %s: %s", resp.Status, string(respBodyBytes))
+// backoff arvutab ooteaja enne järgmist katset: Retry-After päise olemasolul kasutatakse seda,
+// muidu "full jitter" eksponentsiaalset ooteaega vahemikus [0, min(MaxBackoff, InitialBackoff*2^attempt)].
+// Retry-After piiratakse MaxBackoff'i ja konteksti järelejäänud ajaga, et serveri pakutud pikk
+// ooteaeg (nt 3600) ei hoiaks muudatuste rakendamist kinni.
+func (c *ZoneClient) backoff(ctx context.Context, attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		retryAfter = min(retryAfter, c.retry.MaxBackoff)
+		if deadline, ok := ctx.Deadline(); ok {
+			retryAfter = min(retryAfter, max(time.Until(deadline), 0))
+		}
+		return retryAfter
+	}
+	ceiling := c.retry.InitialBackoff << attempt
+	if ceiling <= 0 || ceiling > c.retry.MaxBackoff {
+		ceiling = c.retry.MaxBackoff
 	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
 
-	if responseTarget != nil && len(respBodyBytes) > 0 && resp.StatusCode != http.StatusNoContent {
-		if err := json.Unmarshal(respBodyBytes, responseTarget); err != nil {
-			return fmt.Errorf("failed to unmarshal response body into target type %T: %w. Body: %s", responseTarget, err, string(respBodyBytes))
+// parseRetryAfter parsib Retry-After päise (sekundid või HTTP kuupäev)
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
 		}
 	}
+	return 0
+}
 
-	return nil
+// sleepCtx ootab antud aja või kuni kontekst tühistatakse
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
-// --- Spetsiifilised API meetodid ---
+// RecordListError tähistab ühe kirjetüübi listingu ebaõnnestumist tsoonis.
+// GetZoneRecords tagastab need errors.Join abil kokku liidetuna, seega saab neid kätte errors.As abil.
+type RecordListError struct {
+	Zone       string
+	RecordType string
+	Err        error
+}
 
-// GetZoneEndpoints hangib KÕIK hallatavad kirjed (A, CNAME, TXT, MX, SRV) tsoonist
-// ja konverdib need otse endpoint.Endpoint objektideks.
-func (c *ZoneClient) GetZoneEndpoints(ctx context.Context, zoneName string) ([]*endpoint.Endpoint, error) {
-	var endpoints []*endpoint.Endpoint
-	recordTypes := []string{"a", "cname", "txt", "mx", "srv"} // Hallatavad tüübid
-
-	for _, rt := range recordTypes {
-		path := fmt.Sprintf("/dns/%s/%s", zoneName, strings.ToLower(rt))
-		log.Printf("INFO: Fetching %s records from %s", strings.ToUpper(rt), path)
-
-		switch rt {
-		case "a":
-			var records ZoneARecords
-			if err := c.doRequest(ctx, http.MethodGet, path, nil, &records); err != nil {
-				log.Printf("WARN: Failed to get A records for zone %s: %v", zoneName, err)
-				continue // Jätka teiste tüüpidega
-			}
-			for _, r := range records {
-				// TTL on 0, kuna API seda ei halda
-				ep := endpoint.NewEndpointWithTTL(r.Name, rt, endpoint.TTL(0), r.Destination)
-				ep.SetIdentifier = r.ID // ID on nüüd string
-				endpoints = append(endpoints, ep)
-			}
-		case "cname":
-			var records ZoneCNAMERecords
-			if err := c.doRequest(ctx, http.MethodGet, path, nil, &records); err != nil {
-				log.Printf("WARN: Failed to get CNAME records for zone %s: %v", zoneName, err)
-				continue
-			}
-			for _, r := range records {
-				ep := endpoint.NewEndpointWithTTL(r.Name, rt, endpoint.TTL(0), r.Destination)
-				ep.SetIdentifier = r.ID // ID on nüüd string
-				endpoints = append(endpoints, ep)
-			}
-		case "txt":
-			var records ZoneTXTRecords
-			if err := c.doRequest(ctx, http.MethodGet, path, nil, &records); err != nil {
-				log.Printf("WARN: Failed to get TXT records for zone %s: %v", zoneName, err)
-				continue
-			}
-			for _, r := range records {
-				// TXT kirje sihtmärk (destination) on API vastuses ilma jutumärkideta.
-				// External-DNS võib neid oodata, aga standardne käitumine on ilma.
-				// Jätame siin ilma ja vajadusel kohandame provideris.
-				destination := r.Destination
-				ep := endpoint.NewEndpointWithTTL(r.Name, rt, endpoint.TTL(0), destination)
-				ep.SetIdentifier = r.ID // ID on nüüd string
-				endpoints = append(endpoints, ep)
-			}
-		case "mx":
-			var records ZoneMXRecords
-			if err := c.doRequest(ctx, http.MethodGet, path, nil, &records); err != nil {
-				log.Printf("WARN: Failed to get MX records for zone %s: %v", zoneName, err)
-				continue
-			}
-			for _, r := range records {
-				// Formaat external-dns jaoks: "priority destination"
-				target := fmt.Sprintf("%d %s", r.Priority, r.Destination)
-				ep := endpoint.NewEndpointWithTTL(r.Name, rt, endpoint.TTL(0), target)
-				ep.SetIdentifier = r.ID // ID on nüüd string
-				endpoints = append(endpoints, ep)
-			}
-		case "srv":
-			var records ZoneSRVRecords
-			if err := c.doRequest(ctx, http.MethodGet, path, nil, &records); err != nil {
-				log.Printf("WARN: Failed to get SRV records for zone %s: %v", zoneName, err)
-				continue
-			}
-			for _, r := range records {
-				// Formaat external-dns jaoks: "priority weight port destination"
-				target := fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Destination)
-				ep := endpoint.NewEndpointWithTTL(r.Name, rt, endpoint.TTL(0), target)
-				ep.SetIdentifier = r.ID // ID on nüüd string
-				endpoints = append(endpoints, ep)
+func (e *RecordListError) Error() string {
+	return fmt.Sprintf("failed to list %s records in zone %s: %v", e.RecordType, e.Zone, e.Err)
+}
+
+func (e *RecordListError) Unwrap() error {
+	return e.Err
+}
+
+// canonicalIPv6 valideerib IPv6 aadressi ja tagastab selle RFC 5952 kanoonilisel kujul
+func canonicalIPv6(target string) (string, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(target))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse IPv6 address '%s': %w", target, err)
+	}
+	if !addr.Is6() || addr.Is4In6() || addr.Zone() != "" {
+		return "", fmt.Errorf("'%s' is not a plain IPv6 address", target)
+	}
+	return addr.String(), nil
+}
+
+// formatCAATarget koostab CAA kirje external-dns sihtmärgi kujul `0 issue "letsencrypt.org"`.
+// Väärtuses olevad jutumärgid ja kaldkriipsud escape'itakse, et parseCAATarget saaks sama väärtuse tagasi.
+func formatCAATarget(flag int, tag, value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return fmt.Sprintf(`%d %s "%s"`, flag, strings.ToLower(tag), escaped)
+}
+
+// parseCAATarget parsib sihtmärgi `flag tag value` osadeks.
+// Väärtus võib olla jutumärkides (escape'itud \" ja \\ toega) või ilma (siis kuni rea lõpuni).
+func parseCAATarget(target string) (int, string, string, error) {
+	// Flag ja tag on tühikutega eraldatud väljad (ka mitu tühikut või tab), väärtus on ülejäänud rida
+	var fields [3]string
+	fields[0], fields[2] = nextCAAField(target)
+	fields[1], fields[2] = nextCAAField(fields[2])
+	fields[2] = strings.TrimSpace(fields[2])
+	if fields[2] == "" {
+		return 0, "", "", fmt.Errorf("expected 'flag tag value', got %d field(s)", len(strings.Fields(target)))
+	}
+
+	flag, err := strconv.Atoi(fields[0])
+	if err != nil || flag < 0 || flag > 255 {
+		return 0, "", "", fmt.Errorf("invalid CAA flag '%s', must be 0-255", fields[0])
+	}
+
+	tag := strings.ToLower(fields[1])
+	if tag == "" || strings.IndexFunc(tag, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		return 0, "", "", fmt.Errorf("invalid CAA tag '%s', must be alphanumeric", fields[1])
+	}
+
+	raw := fields[2]
+	if !strings.HasPrefix(raw, `"`) {
+		return flag, tag, raw, nil
+	}
+	if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+		return 0, "", "", fmt.Errorf("unterminated quoted CAA value %s", raw)
+	}
+	var value strings.Builder
+	inner := raw[1 : len(raw)-1]
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '\\':
+			if i+1 >= len(inner) {
+				return 0, "", "", fmt.Errorf("dangling escape in CAA value %s", raw)
 			}
+			i++
+			value.WriteByte(inner[i])
+		case '"':
+			return 0, "", "", fmt.Errorf("unescaped quote in CAA value %s", raw)
 		default:
-			log.Printf("WARN: Unsupported record type %s requested in GetZoneEndpoints", rt)
+			value.WriteByte(inner[i])
+		}
+	}
+	return flag, tag, value.String(), nil
+}
+
+// nextCAAField tagastab s-i esimese tühikutega eraldatud välja ja ülejäänud osa
+func nextCAAField(s string) (string, string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		return s[:i], s[i:]
+	}
+	return s, ""
+}
+
+// --- Spetsiifilised API meetodid ---
+
+// ListZones hangib kõik konto DNS tsoonid
+func (c *ZoneClient) ListZones(ctx context.Context) ([]string, error) {
+	var zones []DNSZone
+	if err := c.doRequest(ctx, http.MethodGet, "/dns", nil, &zones); err != nil {
+		return nil, fmt.Errorf("failed to list DNS zones: %w", err)
+	}
+	names := make([]string, 0, len(zones))
+	for _, z := range zones {
+		if z.Name != "" {
+			names = append(names, strings.ToLower(strings.TrimSuffix(z.Name, ".")))
+		}
+	}
+	return names, nil
+}
+
+// CheckZoneAccess kontrollib, kas tsooni kirjeid saab lugeda. Loetakse ainult NS kirjed,
+// sest need on igas tsoonis olemas ja nende listing on väike.
+func (c *ZoneClient) CheckZoneAccess(ctx context.Context, zoneName string) error {
+	if _, err := c.listRecords(ctx, zoneName, "ns"); err != nil {
+		return fmt.Errorf("zone %s is not readable: %w", zoneName, err)
+	}
+	return nil
+}
+
+// managedRecordTypes on Zone.ee kirjetüübid, mida webhook haldab (API teekonna kujul)
+var managedRecordTypes = []string{"a", "aaaa", "cname", "txt", "mx", "srv", "caa", "ns"}
+
+// GetZoneRecords hangib KÕIK hallatavad kirjed (A, AAAA, CNAME, TXT, MX, SRV, CAA, NS) tsoonist
+// koos Zone.ee ID-dega. Target on juba external-dns formaadis.
+// Kui mõne tüübi listing ebaõnnestub, tagastatakse ülejäänud kirjed koos *RecordListError vigadega.
+func (c *ZoneClient) GetZoneRecords(ctx context.Context, zoneName string) ([]ZoneRecord, error) {
+	// Kirjetüübid loetakse paralleelselt, tulemused pannakse kokku managedRecordTypes järjekorras
+	results := make([][]ZoneRecord, len(managedRecordTypes))
+	errs := make([]error, len(managedRecordTypes))
+	forEachParallel(len(managedRecordTypes), c.concurrency, func(i int) {
+		results[i], errs[i] = c.listRecords(ctx, zoneName, managedRecordTypes[i])
+	})
+
+	var zoneRecords []ZoneRecord
+	var listErrors []error
+	for i, rt := range managedRecordTypes {
+		if errs[i] != nil {
+			logFrom(ctx).Warn("Failed to list records", logKeyZone, zoneName, logKeyRecordType, strings.ToUpper(rt), logKeyError, errs[i])
+			listErrors = append(listErrors, &RecordListError{Zone: zoneName, RecordType: strings.ToUpper(rt), Err: errs[i]})
+			continue // Jätka teiste tüüpidega
+		}
+		zoneRecords = append(zoneRecords, results[i]...)
+	}
+	logFrom(ctx).Info("Finished fetching zone records", logKeyZone, zoneName, "records", len(zoneRecords))
+	return zoneRecords, errors.Join(listErrors...)
+}
+
+// listRecords hangib tsoonist ühe tüübi kirjed ja konverdib sihtmärgid external-dns formaati
+func (c *ZoneClient) listRecords(ctx context.Context, zoneName, rt string) ([]ZoneRecord, error) {
+	rt = strings.ToLower(rt)
+	path := fmt.Sprintf("/dns/%s/%s", zoneName, rt)
+	recordType := strings.ToUpper(rt) // external-dns kasutab suurtähti
+	logFrom(ctx).Debug("Fetching records", logKeyZone, zoneName, logKeyRecordType, recordType)
+
+	response := newRecordsResponse(rt)
+	if response == nil {
+		return nil, fmt.Errorf("unsupported record type %s", recordType)
+	}
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, response); err != nil {
+		return nil, err
+	}
+	return toZoneRecords(ctx, zoneName, rt, response), nil
+}
+
+// newRecordsResponse tagastab kirjetüübile vastava vastuse struktuuri. Zone.ee tagastab kirjete massiivi
+// nii listingul kui ka loomisel ja uuendamisel, seega sobib sama struktuur kõigile.
+func newRecordsResponse(rt string) interface{} {
+	switch rt {
+	case "a":
+		return &ZoneARecords{}
+	case "aaaa":
+		return &ZoneAAAARecords{}
+	case "cname":
+		return &ZoneCNAMERecords{}
+	case "txt":
+		return &ZoneTXTRecords{}
+	case "ns":
+		return &ZoneNSRecords{}
+	case "mx":
+		return &ZoneMXRecords{}
+	case "srv":
+		return &ZoneSRVRecords{}
+	case "caa":
+		return &ZoneCAARecords{}
+	}
+	return nil
+}
+
+// toZoneRecords konverdib dekodeeritud vastuse ZoneRecord kirjeteks, sihtmärgid on external-dns formaadis
+func toZoneRecords(ctx context.Context, zoneName, rt string, response interface{}) []ZoneRecord {
+	recordType := strings.ToUpper(rt) // external-dns kasutab suurtähti
+	var zoneRecords []ZoneRecord
+	var plain []Record
+	switch records := response.(type) {
+	case *ZoneARecords:
+		plain = *records
+	case *ZoneAAAARecords:
+		plain = *records
+	case *ZoneCNAMERecords:
+		plain = *records
+	case *ZoneTXTRecords:
+		// TXT kirje sihtmärk (destination) on API vastuses ilma jutumärkideta.
+		// External-DNS võib neid oodata, aga standardne käitumine on ilma.
+		plain = *records
+	case *ZoneNSRecords:
+		plain = *records
+	case *ZoneMXRecords:
+		for _, r := range *records {
+			// Formaat external-dns jaoks: "priority destination"
+			zoneRecords = append(zoneRecords, ZoneRecord{
+				ID: r.ID, Zone: zoneName, Name: r.Name, RecordType: recordType,
+				Target:    fmt.Sprintf("%d %s", r.Priority, r.Destination),
+				CanModify: r.CanModify, CanDelete: r.CanDelete,
+			})
+		}
+	case *ZoneSRVRecords:
+		for _, r := range *records {
+			// Formaat external-dns jaoks: "priority weight port destination"
+			zoneRecords = append(zoneRecords, ZoneRecord{
+				ID: r.ID, Zone: zoneName, Name: r.Name, RecordType: recordType,
+				Target:    fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Destination),
+				CanModify: r.CanModify, CanDelete: r.CanDelete,
+			})
+		}
+	case *ZoneCAARecords:
+		for _, r := range *records {
+			// Formaat external-dns jaoks: `flag tag "value"`
+			zoneRecords = append(zoneRecords, ZoneRecord{
+				ID: r.ID, Zone: zoneName, Name: r.Name, RecordType: recordType,
+				Target:    formatCAATarget(r.Flag, r.Tag, r.Destination),
+				CanModify: r.CanModify, CanDelete: r.CanDelete,
+			})
+		}
+	}
+	for _, r := range plain {
+		target := r.Destination
+		if rt == "aaaa" {
+			// Kanooniline kuju, et external-dns ei näeks 2001:db8::1 ja laiendatud kuju vahel erinevust
+			if canonical, err := canonicalIPv6(target); err == nil {
+				target = canonical
+			} else {
+				logFrom(ctx).Warn("Zone.ee returned invalid AAAA target", logKeyZone, zoneName, logKeyName, r.Name, logKeyRecordID, r.ID, logKeyTarget, target, logKeyError, err)
+			}
+		}
+		zoneRecords = append(zoneRecords, ZoneRecord{
+			ID: r.ID, Zone: zoneName, Name: r.Name, RecordType: recordType, Target: target,
+			CanModify: r.CanModify, CanDelete: r.CanDelete,
+		})
+	}
+	return zoneRecords
+}
+
+// recordsToEndpoints konverdib Zone.ee kirjed endpoint.Endpoint objektideks.
+// Sama nime ja tüübiga kirjed (nt round-robin A kirjed) koondatakse üheks mitme sihtmärgiga endpointiks.
+// Endpointid on esmakordse esinemise järjekorras, sihtmärgid sorteeritud, et diffid oleksid stabiilsed.
+// TXT kirjeid ei koondata: external-dns TXT registry loeb omaniku silte ainult Targets[0]-st, seega
+// tipus kõrvuti olev nt google-site-verification kirje ei tohi omanikukirjet esimeselt kohalt tõrjuda.
+func recordsToEndpoints(records []ZoneRecord) []*endpoint.Endpoint {
+	type groupKey struct{ name, recordType string }
+	var endpoints []*endpoint.Endpoint
+	groups := make(map[groupKey]*endpoint.Endpoint)
+	for _, r := range records {
+		key := groupKey{strings.ToLower(strings.TrimSuffix(r.Name, ".")), r.RecordType}
+		if ep, ok := groups[key]; ok {
+			ep.Targets = append(ep.Targets, r.Target)
+			continue
+		}
+		// TTL on 0, kuna API seda ei halda
+		ep := endpoint.NewEndpointWithTTL(r.Name, r.RecordType, endpoint.TTL(0), r.Target)
+		if r.RecordType != endpoint.RecordTypeTXT {
+			groups[key] = ep
 		}
+		endpoints = append(endpoints, ep)
+	}
+	for _, ep := range endpoints {
+		sort.Strings(ep.Targets)
 	}
-	log.Printf("INFO: Finished fetching records for zone %s, found %d endpoints.", zoneName, len(endpoints))
-	return endpoints, nil
+	return endpoints
 }
 
-// CreateRecord loob uue kirje
-func (c *ZoneClient) CreateRecord(ctx context.Context, zoneName string, ep *endpoint.Endpoint) error {
+// CreateRecord loob uue kirje ja tagastab Zone.ee poolt loodud kirje(d) koos ID-ga
+func (c *ZoneClient) CreateRecord(ctx context.Context, zoneName string, ep *endpoint.Endpoint) ([]ZoneRecord, error) {
 	recordType := strings.ToLower(ep.RecordType)
 	path := fmt.Sprintf("/dns/%s/%s", zoneName, recordType)
 	var payload interface{}
-	var responseTarget interface{} // Vajalik vastuse valideerimiseks
 	var err error
 
-	// Eeldame ühte sihtmärki Zone API piirangute tõttu
+	// Zone.ee kirjel on üks sihtmärk, mitme sihtmärgiga endpointid jagab provider kirjeteks
 	if len(ep.Targets) != 1 {
-		return fmt.Errorf("expected exactly one target for creating record %s %s, got %d", ep.DNSName, ep.RecordType, len(ep.Targets))
+		return nil, fmt.Errorf("expected exactly one target for creating record %s %s, got %d", ep.DNSName, ep.RecordType, len(ep.Targets))
 	}
 	target := ep.Targets[0]
 
@@ -197,61 +671,111 @@ func (c *ZoneClient) CreateRecord(ctx context.Context, zoneName string, ep *endp
 	case "a", "cname":
 		p := CreateRecordPayload{Name: ep.DNSName, Destination: target}
 		payload = p
-		responseTarget = &ZoneARecords{} // Ootame massiivi tagasi
+	case "ns":
+		p := CreateRecordPayload{Name: ep.DNSName, Destination: target}
+		payload = p
+	case "aaaa":
+		dest, err := canonicalIPv6(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AAAA target for %s: %w", ep.DNSName, err)
+		}
+		p := CreateRecordPayload{Name: ep.DNSName, Destination: dest}
+		payload = p
 	case "txt":
 		// Kui external-dns lisab jutumärgid, võtame need siin ära, kui API neid ei taha
 		// destination := strings.Trim(target, "\"")
 		destination := target // Eeldame, et API ei taha jutumärke
 		p := CreateRecordPayload{Name: ep.DNSName, Destination: destination}
 		payload = p
-		responseTarget = &ZoneTXTRecords{}
 	case "mx":
 		var prio int
 		var dest string
 		_, err = fmt.Sscan(target, &prio, &dest)
 		if err != nil {
-			return fmt.Errorf("failed to parse MX target '%s': %w", target, err)
+			return nil, fmt.Errorf("failed to parse MX target '%s': %w", target, err)
 		}
 		p := CreateMXPayload{Name: ep.DNSName, Destination: dest, Priority: prio}
 		payload = p
-		responseTarget = &ZoneMXRecords{}
 	case "srv":
 		var prio, weight, port int
 		var dest string
 		_, err = fmt.Sscan(target, &prio, &weight, &port, &dest)
 		if err != nil {
-			return fmt.Errorf("failed to parse SRV target '%s': %w", target, err)
+			return nil, fmt.Errorf("failed to parse SRV target '%s': %w", target, err)
 		}
 		p := CreateSRVPayload{Name: ep.DNSName, Destination: dest, Priority: prio, Weight: weight, Port: port}
 		payload = p
-		responseTarget = &ZoneSRVRecords{}
+	case "caa":
+		flag, tag, value, err := parseCAATarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CAA target '%s': %w", target, err)
+		}
+		p := CreateCAAPayload{Name: ep.DNSName, Destination: value, Flag: flag, Tag: tag}
+		payload = p
 	default:
-		return fmt.Errorf("unsupported record type for creation: %s", ep.RecordType)
+		return nil, fmt.Errorf("unsupported record type for creation: %s", ep.RecordType)
 	}
 
-	// Teeme päringu ja proovime vastust töödelda
+	responseTarget := newRecordsResponse(recordType)
+
+	// Teeme päringu ja proovime vastust töödelda.
+	// Kui POST tulemus jäi teadmata (timeout, 5xx), kontrollime enne kordamist, kas kirje siiski tekkis,
+	// et mitte luua duplikaate.
 	err = c.doRequest(ctx, http.MethodPost, path, payload, responseTarget)
+	for attempt := 0; errors.Is(err, errAmbiguousRequest) && attempt < c.retry.MaxRetries; attempt++ {
+		logFrom(ctx).Warn("Create has unknown outcome, checking before retry",
+			logKeyZone, zoneName, logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyTarget, target, logKeyError, err)
+		if waitErr := sleepCtx(ctx, c.backoff(ctx, attempt, 0)); waitErr != nil {
+			break
+		}
+		existing, checkErr := c.findRecords(ctx, zoneName, recordType, ep.DNSName, target)
+		if checkErr != nil {
+			err = fmt.Errorf("%w (existence check failed: %v)", err, checkErr)
+			break
+		}
+		if len(existing) > 0 {
+			logFrom(ctx).Info("Record already exists after ambiguous create, treating as success",
+				logKeyZone, zoneName, logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyTarget, target, logKeyRecordID, existing[0].ID)
+			return existing, nil
+		}
+		err = c.doRequest(ctx, http.MethodPost, path, payload, responseTarget)
+	}
 	if err != nil {
 		// Viga võis tulla nii API päringust kui ka vastuse Unmarshalist
-		return fmt.Errorf("failed during create %s record API call or response processing for %s in zone %s: %w", ep.RecordType, ep.DNSName, zoneName, err)
+		return nil, fmt.Errorf("failed during create %s record API call or response processing for %s in zone %s: %w", ep.RecordType, ep.DNSName, zoneName, err)
 	}
 	// Kui viga ei tekkinud, on kõik korras
-	return nil
+	return toZoneRecords(ctx, zoneName, recordType, responseTarget), nil
+}
+
+// findRecords tagastab tsooni kirjed, mille nimi, tüüp ja sihtmärk vastavad antud väärtustele
+func (c *ZoneClient) findRecords(ctx context.Context, zoneName, recordType, name, target string) ([]ZoneRecord, error) {
+	records, err := c.listRecords(ctx, zoneName, recordType)
+	if err != nil {
+		return nil, err
+	}
+	want := newRecordKey(name, recordType, target)
+	var found []ZoneRecord
+	for _, r := range records {
+		if newRecordKey(r.Name, r.RecordType, r.Target) == want {
+			found = append(found, r)
+		}
+	}
+	return found, nil
 }
 
-// UpdateRecord uuendab olemasolevat kirjet ID järgi
-// recordID on int, kuna see tuleb SetIdentifierist (string), mis teisendatakse int-iks provideris
-func (c *ZoneClient) UpdateRecord(ctx context.Context, zoneName string, recordID int, ep *endpoint.Endpoint) error {
+// UpdateRecord uuendab olemasolevat kirjet ID järgi ja tagastab uuendatud kirje(d)
+// recordID on int, provider leiab selle oma kirjete indeksist
+func (c *ZoneClient) UpdateRecord(ctx context.Context, zoneName string, recordID int, ep *endpoint.Endpoint) ([]ZoneRecord, error) {
 	recordType := strings.ToLower(ep.RecordType)
 	// API path ootab ID-d numbrina (või stringina, mis on number)
 	path := fmt.Sprintf("/dns/%s/%s/%d", zoneName, recordType, recordID)
 	var payload interface{}
-	var responseTarget interface{} // Vajalik vastuse valideerimiseks
 	var err error
 
-	// Eeldame ühte sihtmärki
+	// Zone.ee kirjel on üks sihtmärk, mitme sihtmärgiga endpointid jagab provider kirjeteks
 	if len(ep.Targets) != 1 {
-		return fmt.Errorf("expected exactly one target for updating record %s %s (ID: %d), got %d", ep.DNSName, ep.RecordType, recordID, len(ep.Targets))
+		return nil, fmt.Errorf("expected exactly one target for updating record %s %s (ID: %d), got %d", ep.DNSName, ep.RecordType, recordID, len(ep.Targets))
 	}
 	target := ep.Targets[0]
 
@@ -259,47 +783,62 @@ func (c *ZoneClient) UpdateRecord(ctx context.Context, zoneName string, recordID
 	case "a", "cname":
 		p := UpdateRecordPayload{Name: ep.DNSName, Destination: target}
 		payload = p
-		responseTarget = &ZoneARecords{}
+	case "ns":
+		p := UpdateRecordPayload{Name: ep.DNSName, Destination: target}
+		payload = p
+	case "aaaa":
+		dest, err := canonicalIPv6(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AAAA target for %s (ID: %d): %w", ep.DNSName, recordID, err)
+		}
+		p := UpdateRecordPayload{Name: ep.DNSName, Destination: dest}
+		payload = p
 	case "txt":
 		// destination := strings.Trim(target, "\"")
 		destination := target
 		p := UpdateRecordPayload{Name: ep.DNSName, Destination: destination}
 		payload = p
-		responseTarget = &ZoneTXTRecords{}
 	case "mx":
 		var prio int
 		var dest string
 		_, err = fmt.Sscan(target, &prio, &dest)
 		if err != nil {
-			return fmt.Errorf("failed to parse MX target '%s' for update: %w", target, err)
+			return nil, fmt.Errorf("failed to parse MX target '%s' for update: %w", target, err)
 		}
 		p := UpdateMXPayload{Name: ep.DNSName, Destination: dest, Priority: prio}
 		payload = p
-		responseTarget = &ZoneMXRecords{}
 	case "srv":
 		var prio, weight, port int
 		var dest string
 		_, err = fmt.Sscan(target, &prio, &weight, &port, &dest)
 		if err != nil {
-			return fmt.Errorf("failed to parse SRV target '%s' for update: %w", target, err)
+			return nil, fmt.Errorf("failed to parse SRV target '%s' for update: %w", target, err)
 		}
 		p := UpdateSRVPayload{Name: ep.DNSName, Destination: dest, Priority: prio, Weight: weight, Port: port}
 		payload = p
-		responseTarget = &ZoneSRVRecords{}
+	case "caa":
+		flag, tag, value, err := parseCAATarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CAA target '%s' for update: %w", target, err)
+		}
+		p := UpdateCAAPayload{Name: ep.DNSName, Destination: value, Flag: flag, Tag: tag}
+		payload = p
 	default:
-		return fmt.Errorf("unsupported record type for update: %s", ep.RecordType)
+		return nil, fmt.Errorf("unsupported record type for update: %s", ep.RecordType)
 	}
 
+	responseTarget := newRecordsResponse(recordType)
+
 	// PUT päring tagastab 200 OK
 	err = c.doRequest(ctx, http.MethodPut, path, payload, responseTarget)
 	if err != nil {
-		return fmt.Errorf("failed during update %s record API call or response processing for ID %d in zone %s: %w", ep.RecordType, recordID, zoneName, err)
+		return nil, fmt.Errorf("failed during update %s record API call or response processing for ID %d in zone %s: %w", ep.RecordType, recordID, zoneName, err)
 	}
-	return nil
+	return toZoneRecords(ctx, zoneName, recordType, responseTarget), nil
 }
 
 // DeleteRecord kustutab kirje ID järgi
-// recordID on int, kuna see tuleb SetIdentifierist (string), mis teisendatakse int-iks provideris
+// recordID on int, provider leiab selle oma kirjete indeksist
 func (c *ZoneClient) DeleteRecord(ctx context.Context, zoneName, recordType string, recordID int) error {
 	// API path ootab ID-d numbrina (või stringina, mis on number)
 	path := fmt.Sprintf("/dns/%s/%s/%d", zoneName, strings.ToLower(recordType), recordID)
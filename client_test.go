@@ -0,0 +1,320 @@
+// Fail: client_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// newTestClient loob kliendi, mis suhtleb httptest serveriga kiirete korduste ja ilma piirajata
+func newTestClient(t *testing.T, handler http.Handler, maxRetries int) *ZoneClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewZoneClient("user", "key",
+		WithBaseURL(server.URL),
+		WithRetry(RetryConfig{MaxRetries: maxRetries, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}),
+		WithRateLimit(RateLimitConfig{}),
+	)
+}
+
+func TestDoRequestRetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[{"name":"example.ee"}]`))
+	}), 3)
+
+	zones, err := client.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("ListZones: %v", err)
+	}
+	if len(zones) != 1 || zones[0] != "example.ee" {
+		t.Errorf("zones = %v, want [example.ee]", zones)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("calls = %d, want 3", got)
+	}
+}
+
+func TestDoRequestGivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}), 2)
+
+	_, err := client.ListZones(context.Background())
+	var apiErr *ZoneAPIError
+	if !errors.As(err, &apiErr) || !apiErr.Retryable {
+		t.Fatalf("err = %v, want retryable *ZoneAPIError", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("calls = %d, want 3 (1 attempt + 2 retries)", got)
+	}
+}
+
+func TestDoRequestDoesNotRetryPermanentErrors(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Zone not found"}`))
+	}), 3)
+
+	_, err := client.ListZones(context.Background())
+	var apiErr *ZoneAPIError
+	if !errors.As(err, &apiErr) || !apiErr.IsNotFound() || apiErr.Message != "Zone not found" {
+		t.Fatalf("err = %v, want not found *ZoneAPIError", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+}
+
+// retryAfterAPI vastab esimesele päringule 429 antud Retry-After päisega ja seejärel edukalt
+func retryAfterAPI(retryAfter string) http.Handler {
+	var calls atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+}
+
+func TestDoRequestHonoursRetryAfter(t *testing.T) {
+	server := httptest.NewServer(retryAfterAPI("1"))
+	t.Cleanup(server.Close)
+	client := NewZoneClient("user", "key",
+		WithBaseURL(server.URL),
+		WithRetry(RetryConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Second}),
+		WithRateLimit(RateLimitConfig{}),
+	)
+
+	start := time.Now()
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("ListZones: %v", err)
+	}
+	// Ilma Retry-After päiseta oleks ooteaeg maksimaalselt 1ms
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retry happened after %s, want at least the 1s from Retry-After", elapsed)
+	}
+}
+
+func TestDoRequestCapsRetryAfter(t *testing.T) {
+	// MaxBackoff on newTestClient'is 5ms, seega tunnine Retry-After ei tohi päringut kinni hoida
+	client := newTestClient(t, retryAfterAPI("3600"), 3)
+	start := time.Now()
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("ListZones: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry waited %s, want Retry-After capped to MaxBackoff", elapsed)
+	}
+}
+
+func TestBackoffCapsRetryAfterToDeadline(t *testing.T) {
+	client := NewZoneClient("user", "key", WithRetry(RetryConfig{MaxRetries: 3, MaxBackoff: time.Minute}))
+	if got := client.backoff(context.Background(), 0, time.Hour); got != time.Minute {
+		t.Errorf("backoff without deadline = %s, want MaxBackoff 1m", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if got := client.backoff(ctx, 0, time.Hour); got > 2*time.Second || got < time.Second {
+		t.Errorf("backoff with 2s deadline = %s, want about the remaining 2s", got)
+	}
+	if got := client.backoff(ctx, 0, 10*time.Millisecond); got != 10*time.Millisecond {
+		t.Errorf("short Retry-After = %s, want it unchanged", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{" 2 ", 2 * time.Second, 2 * time.Second},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+// fakeCreateAPI simuleerib POST /dns/example.ee/a päringuid, mille tulemus võib jääda teadmata
+type fakeCreateAPI struct {
+	postStatuses []int  // Järjestikuste POST päringute vastused, 201 loob kirje
+	existing     string // GET vastus
+	posts, gets  atomic.Int32
+}
+
+func (f *fakeCreateAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/dns/example.ee/a" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		f.gets.Add(1)
+		w.Write([]byte(f.existing))
+	case http.MethodPost:
+		n := int(f.posts.Add(1))
+		status := http.StatusCreated
+		if n <= len(f.postStatuses) {
+			status = f.postStatuses[n-1]
+		}
+		w.WriteHeader(status)
+		if status == http.StatusCreated {
+			w.Write([]byte(`[{"id":"42","name":"www.example.ee","destination":"1.2.3.4","modify":true,"delete":true}]`))
+		}
+	}
+}
+
+func TestCreateRecordAmbiguousPostFindsExistingRecord(t *testing.T) {
+	api := &fakeCreateAPI{
+		postStatuses: []int{http.StatusBadGateway},
+		existing:     `[{"id":"7","name":"www.example.ee","destination":"1.2.3.4","modify":true,"delete":true}]`,
+	}
+	client := newTestClient(t, api, 3)
+
+	records, err := client.CreateRecord(context.Background(), "example.ee", endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4"))
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "7" {
+		t.Errorf("records = %+v, want the existing record 7", records)
+	}
+	if posts, gets := api.posts.Load(), api.gets.Load(); posts != 1 || gets != 1 {
+		t.Errorf("posts = %d, gets = %d, want 1 POST and 1 existence check", posts, gets)
+	}
+}
+
+func TestCreateRecordAmbiguousPostRetriesWhenMissing(t *testing.T) {
+	api := &fakeCreateAPI{postStatuses: []int{http.StatusBadGateway}, existing: `[]`}
+	client := newTestClient(t, api, 3)
+
+	records, err := client.CreateRecord(context.Background(), "example.ee", endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4"))
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "42" {
+		t.Errorf("records = %+v, want the created record 42", records)
+	}
+	if posts, gets := api.posts.Load(), api.gets.Load(); posts != 2 || gets != 1 {
+		t.Errorf("posts = %d, gets = %d, want 2 POSTs and 1 existence check", posts, gets)
+	}
+}
+
+func TestCreateRecordThrottledPostRetriesWithoutCheck(t *testing.T) {
+	api := &fakeCreateAPI{postStatuses: []int{http.StatusTooManyRequests}, existing: `[]`}
+	client := newTestClient(t, api, 3)
+
+	if _, err := client.CreateRecord(context.Background(), "example.ee", endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4")); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if posts, gets := api.posts.Load(), api.gets.Load(); posts != 2 || gets != 0 {
+		t.Errorf("posts = %d, gets = %d, want 2 POSTs and no existence check after 429", posts, gets)
+	}
+}
+
+func TestCreateRecordAmbiguousPostWithoutRetriesFails(t *testing.T) {
+	api := &fakeCreateAPI{postStatuses: []int{http.StatusBadGateway}, existing: `[]`}
+	client := newTestClient(t, api, 0)
+
+	_, err := client.CreateRecord(context.Background(), "example.ee", endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4"))
+	if !errors.Is(err, errAmbiguousRequest) {
+		t.Fatalf("err = %v, want errAmbiguousRequest", err)
+	}
+	if posts := api.posts.Load(); posts != 1 {
+		t.Errorf("posts = %d, want 1", posts)
+	}
+}
+
+func TestParseCAATarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		flag    int
+		tag     string
+		value   string
+		wantErr bool
+	}{
+		{target: `0 issue "letsencrypt.org"`, flag: 0, tag: "issue", value: "letsencrypt.org"},
+		{target: `0  issue  "letsencrypt.org"`, flag: 0, tag: "issue", value: "letsencrypt.org"},
+		{target: "128\tIssueWild\t\"ca.example\"", flag: 128, tag: "issuewild", value: "ca.example"},
+		{target: `  0 iodef mailto:security@example.ee  `, flag: 0, tag: "iodef", value: "mailto:security@example.ee"},
+		{target: `0 issue "ca.example; account=\"a b\""`, flag: 0, tag: "issue", value: `ca.example; account="a b"`},
+		{target: `0 issue "back\\slash"`, flag: 0, tag: "issue", value: `back\slash`},
+		{target: `0 issue ""`, flag: 0, tag: "issue", value: ""},
+		{target: `0 issue`, wantErr: true},
+		{target: `0  issue  `, wantErr: true},
+		{target: `256 issue "x"`, wantErr: true},
+		{target: `x issue "x"`, wantErr: true},
+		{target: `0 is-sue "x"`, wantErr: true},
+		{target: `0 issue "x`, wantErr: true},
+		{target: `0 issue "x\"`, wantErr: true},
+		{target: `0 issue "a"b"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		flag, tag, value, err := parseCAATarget(tt.target)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCAATarget(%q) = %d %q %q, want error", tt.target, flag, tag, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCAATarget(%q): %v", tt.target, err)
+			continue
+		}
+		if flag != tt.flag || tag != tt.tag || value != tt.value {
+			t.Errorf("parseCAATarget(%q) = %d %q %q, want %d %q %q", tt.target, flag, tag, value, tt.flag, tt.tag, tt.value)
+		}
+	}
+}
+
+func TestFormatCAATargetRoundTrip(t *testing.T) {
+	values := []string{
+		"letsencrypt.org",
+		"",
+		"ca.example; account=123",
+		`quote " inside`,
+		`back\slash`,
+		`escaped \" quote`,
+		`trailing\`,
+		`\\`,
+		`""`,
+	}
+	for _, value := range values {
+		target := formatCAATarget(0, "Issue", value)
+		flag, tag, got, err := parseCAATarget(target)
+		if err != nil {
+			t.Errorf("parseCAATarget(%q) for value %q: %v", target, value, err)
+			continue
+		}
+		if flag != 0 || tag != "issue" || got != value {
+			t.Errorf("round trip of %q via %q = %d %q %q", value, target, flag, tag, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Fail: errors.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ZoneAPIError kirjeldab Zone.ee API poolt tagasi lükatud päringut.
+// Kasuta errors.As, et eristada nt dubleeritud kirjet, vigaseid volitusi ja puuduvat tsooni.
+type ZoneAPIError struct {
+	Method     string // HTTP meetod, nt "POST"
+	Path       string // API teekond ilma baasaadressita, nt "/dns/example.ee/a"
+	StatusCode int
+	Status     string // nt "422 Unprocessable Entity"
+	Message    string // Zone.ee veateade vastuse kehast (või toores keha, kui seda ei õnnestunud parsida)
+	Retryable  bool   // true 429 ja 5xx korral, muud vead on püsivad
+}
+
+func (e *ZoneAPIError) Error() string {
+	kind := "permanent"
+	if e.Retryable {
+		kind = "retryable"
+	}
+	return fmt.Sprintf("zone.ee api %s %s failed with status %s (%s): %s", e.Method, e.Path, e.Status, kind, e.Message)
+}
+
+// IsUnauthorized on true, kui Zone.ee lükkas volitused tagasi (vale kasutaja või API võti)
+func (e *ZoneAPIError) IsUnauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// IsNotFound on true, kui tsooni või kirjet ei leitud
+func (e *ZoneAPIError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// IsDuplicate on true, kui loodav kirje on Zone.ee-s juba olemas
+func (e *ZoneAPIError) IsDuplicate() bool {
+	if e.StatusCode == http.StatusConflict {
+		return true
+	}
+	if e.StatusCode != http.StatusBadRequest && e.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "already exists") || strings.Contains(msg, "duplicate")
+}
+
+// newZoneAPIError loob vea vastuse staatuse ja keha põhjal
+func newZoneAPIError(method, path string, resp *apiResponse) *ZoneAPIError {
+	return &ZoneAPIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: resp.status,
+		Status:     resp.statusText,
+		Message:    parseZoneErrorMessage(resp.body),
+		Retryable:  resp.status == http.StatusTooManyRequests || resp.status >= 500,
+	}
+}
+
+// parseZoneErrorMessage võtab Zone.ee veakehast inimloetava teate.
+// Zone.ee tagastab tavaliselt {"message": "..."}, valideerimisvigade korral ka "errors" välja.
+func parseZoneErrorMessage(body []byte) string {
+	var parsed struct {
+		Message string          `json:"message"`
+		Error   string          `json:"error"`
+		Errors  json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		parts := []string{}
+		for _, p := range []string{parsed.Message, parsed.Error} {
+			if p != "" {
+				parts = append(parts, p)
+			}
+		}
+		if len(parsed.Errors) > 0 && string(parsed.Errors) != "null" {
+			parts = append(parts, string(parsed.Errors))
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, ": ")
+		}
+	}
+
+	// Keha polnud oodatud kujul, tagastame selle lühendatult
+	raw := strings.TrimSpace(string(body))
+	if len(raw) > 512 {
+		raw = raw[:512] + "..."
+	}
+	if raw == "" {
+		return "(empty response body)"
+	}
+	return raw
+}
+
+// isUnauthorized kontrollib, kas vea ahelas on volituste viga
+func isUnauthorized(err error) bool {
+	var apiErr *ZoneAPIError
+	return errors.As(err, &apiErr) && apiErr.IsUnauthorized()
+}
+
+// ApplyError koondab ApplyChanges käigus tekkinud vead, iga viga on kättesaadav errors.As abil
+type ApplyError struct {
+	Errors []error
+	// Aborted on true, kui rakendamine katkestati enneaegselt (nt vigaste volituste tõttu)
+	Aborted bool
+}
+
+func (e *ApplyError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	prefix := "encountered"
+	if e.Aborted {
+		prefix = "aborted after"
+	}
+	return fmt.Sprintf("%s %d error(s) during apply changes: %s", prefix, len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *ApplyError) Unwrap() []error {
+	return e.Errors
+}
+
+// InvalidChangesError kirjeldab vigase kujuga muudatuste päringut (nt UpdateOld ja UpdateNew erineva pikkusega).
+// Webhook vastab sellele 400, sest kordamine ei aita.
+type InvalidChangesError struct {
+	Reason string
+}
+
+func (e *InvalidChangesError) Error() string {
+	return "invalid changes: " + e.Reason
+}
+
+// ZoneMatchError kirjeldab endpointi, mis ei kuulu ühtegi hallatavasse tsooni ja lükati seetõttu tagasi
+type ZoneMatchError struct {
+	Op         string // "create", "update" või "delete"
+	DNSName    string
+	RecordType string
+	Zones      []string // Hallatavad tsoonid otsuse tegemise hetkel
+}
+
+func (e *ZoneMatchError) Error() string {
+	return fmt.Sprintf("rejected %s of %s %s: name is not in any managed zone %v", e.Op, e.RecordType, e.DNSName, e.Zones)
+}
+
+// DomainFilterError kirjeldab endpointi, mis jääb selle instantsi domeenifiltrist välja.
+// Nii ei saa sama tsooni jagavad external-dns instantsid muuta teineteise kirjeid.
+type DomainFilterError struct {
+	Op         string // "create", "update" või "delete"
+	DNSName    string
+	RecordType string
+}
+
+func (e *DomainFilterError) Error() string {
+	return fmt.Sprintf("rejected %s of %s %s: name is outside the domain filter", e.Op, e.RecordType, e.DNSName)
+}
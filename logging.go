@@ -0,0 +1,179 @@
+// Fail: logging.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Logi väljade nimed, mida kasutatakse kõikjal ühtemoodi
+const (
+	logKeyZone       = "zone"
+	logKeyRecordType = "record_type"
+	logKeyName       = "name"
+	logKeyRecordID   = "record_id"
+	logKeyTarget     = "target"
+	logKeyRequestID  = "request_id"
+	logKeyTraceID    = "trace_id"
+	logKeyError      = "error"
+)
+
+// redactedValue asendab logidest eemaldatud väärtused
+const redactedValue = "[REDACTED]"
+
+// maxLoggedBodyBytes piirab debug tasemel logitava API keha pikkust
+const maxLoggedBodyBytes = 2048
+
+// LogConfig kirjeldab logimise seadistust
+type LogConfig struct {
+	Level  string // debug, info, warn või error
+	Format string // text või json
+	// Redactor eemaldab saladused igast logireast. Sama redaktorit kasutavad spanid (TracingConfig.Redactor).
+	// nil korral eemaldatakse ainult Authorization päised.
+	Redactor *redactor
+}
+
+// authHeaderPattern leiab Authorization päise väärtused, need eemaldatakse alati
+var authHeaderPattern = regexp.MustCompile(`(?i)\b(basic|bearer)\s+[A-Za-z0-9+/=._~-]+`)
+
+// sensitiveKeys on logivõtmed, mille väärtust ei logita kunagi
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"api_key":       true,
+	"apikey":        true,
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+}
+
+// redactor eemaldab logiväärtustest saladused ja tundlikud TXT väärtused
+type redactor struct {
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+// newRedactor loob redaktori. secrets on sõnasõnalised väärtused (nt API võti), sensitiveTXT on
+// regulaaravaldised, mille vasted (nt DKIM võtmed, domeeni kinnitustokenid) eemaldatakse. Vigane muster tagastab vea.
+func newRedactor(secrets, sensitiveTXT []string) (*redactor, error) {
+	r := &redactor{}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	for _, pattern := range sensitiveTXT {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensitive TXT pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// redact tagastab s-i, kus saladused ja tundlikud väärtused on asendatud. nil redaktor eemaldab
+// ainult Authorization päised.
+func (r *redactor) redact(s string) string {
+	if r == nil {
+		return authHeaderPattern.ReplaceAllString(s, "$1 "+redactedValue)
+	}
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	s = authHeaderPattern.ReplaceAllString(s, "$1 "+redactedValue)
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redactedValue)
+	}
+	return s
+}
+
+// replaceAttr on slog.HandlerOptions.ReplaceAttr, mis rakendab redaktorit igale väärtusele (ka sõnumile)
+func (r *redactor) replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, r.redact(v.Error()))
+		case loggedBody:
+			return slog.String(a.Key, truncateBody(r.redact(string(v))))
+		case fmt.Stringer:
+			return slog.String(a.Key, r.redact(v.String()))
+		case []string:
+			redacted := make([]string, len(v))
+			for i, s := range v {
+				redacted[i] = r.redact(s)
+			}
+			return slog.Any(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// newLogger loob seadistusele vastava slog loggeri
+func newLogger(w io.Writer, cfg LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: cfg.Redactor.replaceAttr}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", cfg.Format)
+	}
+}
+
+// loggedBody on debug tasemel logitav API keha. replaceAttr eemaldab saladused kogu kehast ja
+// lühendab selle alles seejärel, nii ei jää lühendamise piirilt pooleks lõigatud saladus logisse.
+type loggedBody string
+
+// String tagastab lühendatud keha loggeritele, millel replaceAttr puudub
+func (b loggedBody) String() string {
+	return truncateBody(string(b))
+}
+
+// truncateBody lühendab debug logi jaoks liiga pika API keha
+func truncateBody(body string) string {
+	if len(body) > maxLoggedBodyBytes {
+		return body[:maxLoggedBodyBytes] + "...(truncated)"
+	}
+	return body
+}
+
+type loggerKey struct{}
+
+// withLogger seob loggeri kontekstiga, nii kannavad kõik päringu logiread sama request_id välja
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// logFrom tagastab kontekstiga seotud loggeri või vaikimisi loggeri
+func logFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newRequestID loob lühikese juhusliku päringu ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,33 @@
+// Fail: logging_test.go
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoggedBodyRedactsBeforeTruncating(t *testing.T) {
+	const secret = "s3cr3t-api-key-value"
+	r, err := newRedactor([]string{secret}, nil)
+	if err != nil {
+		t.Fatalf("newRedactor: %v", err)
+	}
+	var out bytes.Buffer
+	logger, err := newLogger(&out, LogConfig{Level: "debug", Format: "json", Redactor: r})
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+
+	// Saladus algab 10 baiti enne lühendamise piiri, nii et piirini jõuab ainult selle algus
+	body := strings.Repeat("x", maxLoggedBodyBytes-10) + secret + strings.Repeat("y", 100)
+	logger.Debug("Zone.ee API response", "body", loggedBody(body))
+
+	logged := out.String()
+	if strings.Contains(logged, secret[:10]) {
+		t.Errorf("log contains the part of the secret before the truncation boundary:\n%s", logged)
+	}
+	if !strings.Contains(logged, redactedValue) || !strings.Contains(logged, "...(truncated)") {
+		t.Errorf("log body is not redacted and truncated:\n%s", logged)
+	}
+}
@@ -3,190 +3,369 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"sigs.k8s.io/external-dns/endpoint"
-	"sigs.k8s.io/external-dns/plan"
-	// Eemaldasime provider impordi, kuna kasutame lokaalset Capabilities structi
 )
 
 var (
-	zoneUsername string
-	zoneApiKey   string
-	domainFilter string
-	listenAddr   string
-	dryRun       bool
+	zoneUsername  string
+	zoneApiKey    string
+	domainFilter  string
+	listenAddr    string
+	dryRun        bool
+	strictRecords bool
+	zoneAPIBase   string
+	zoneTimeout   time.Duration
+	httpProxy     string
+	caBundle      string
+	maxRetries    int
+	retryBackoff  time.Duration
+	retryMaxWait  time.Duration
+	rateLimit     float64
+	rateBurst     int
+	rateWindow    time.Duration
+	excludeDomain string
+	regexFilter   string
+	regexExclude  string
+	zoneRefresh   time.Duration
+	cacheMaxAge   time.Duration
+	concurrency   int
+	reqDeadline   time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	idleTimeout   time.Duration
+	shutdownWait  time.Duration
+	metricsAddr   string
+	logLevel      string
+	logFormat     string
+	logRedactTXT  string
+	otlpEndpoint  string
+	accessCheck   time.Duration
+	authMode      string
+	authSecret    string
+	traceSampling float64
 )
 
-// Lokaalne Capabilities struktuur (jääb samaks)
-type Capabilities struct {
-	CanAdjustEndpoints bool `json:"canAdjustEndpoints"`
-}
+// HTTP serveri vaikimisi ajalimiidid
+const (
+	defaultReadTimeout     = 30 * time.Second
+	defaultWriteTimeout    = 90 * time.Second // Peab olema pikem kui päringu ajalimiit, muidu vastus lõigatakse ära
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 25 * time.Second // Mahub Kubernetese vaikimisi 30s terminationGracePeriodSeconds sisse
+)
 
-func init() {
+// parseFlags loeb seadistuse lippudest ja keskkonnamuutujatest. Seda kutsutakse main'ist, mitte init'ist,
+// et testid saaksid paketti laadida ilma rakenduse lippe parsimata.
+func parseFlags() {
 	// Konfiguratsiooni lugemine (jääb samaks)
 	flag.StringVar(&zoneUsername, "zone-username", os.Getenv("ZONEEE_API_USER"), "Zone.ee API Username (or ZONEEE_API_USER env var)")
 	flag.StringVar(&zoneApiKey, "zone-api-key", os.Getenv("ZONEEE_API_KEY"), "Zone.ee API Key (or ZONEEE_API_KEY env var)")
-	flag.StringVar(&domainFilter, "domain-filter", os.Getenv("ZONEEE_DOMAIN_FILTER"), "Comma separated list of exact zones to manage (or ZONEEE_DOMAIN_FILTER env var)")
-	flag.StringVar(&listenAddr, "listen-addr", ":8888", "Address to listen on for webhook requests")
+	flag.StringVar(&domainFilter, "domain-filter", os.Getenv("ZONEEE_DOMAIN_FILTER"), "Comma separated domain suffixes to manage, empty manages every zone on the account (or ZONEEE_DOMAIN_FILTER env var)")
+	flag.StringVar(&listenAddr, "listen-addr", ":8888", "Address to listen on for webhook requests, e.g. localhost:8888 when running as an external-dns sidecar")
+	flag.StringVar(&metricsAddr, "metrics-addr", envString("ZONEEE_METRICS_ADDR", DefaultMetricsAddr), "Address for /healthz, /readyz and /metrics, empty serves them on -listen-addr (or ZONEEE_METRICS_ADDR env var)")
 	flag.BoolVar(&dryRun, "dry-run", false, "Enable dry run mode (log changes without applying)")
+	flag.BoolVar(&strictRecords, "strict-records", envBool("ZONEEE_STRICT_RECORDS", true), "Fail GET /records with 5xx when any zone or record type listing fails (or ZONEEE_STRICT_RECORDS env var)")
+	flag.StringVar(&zoneAPIBase, "zone-api-url", envString("ZONEEE_API_URL", zoneAPIURL), "Zone.ee API base URL (or ZONEEE_API_URL env var)")
+	flag.DurationVar(&zoneTimeout, "zone-api-timeout", envDuration("ZONEEE_API_TIMEOUT", defaultRequestTimeout), "Timeout for a single Zone.ee API request (or ZONEEE_API_TIMEOUT env var)")
+	flag.StringVar(&httpProxy, "http-proxy", os.Getenv("ZONEEE_HTTP_PROXY"), "HTTP proxy URL for Zone.ee API requests, defaults to HTTPS_PROXY/NO_PROXY (or ZONEEE_HTTP_PROXY env var)")
+	flag.StringVar(&caBundle, "ca-bundle", os.Getenv("ZONEEE_CA_BUNDLE"), "PEM file with extra CA certificates for the Zone.ee API (or ZONEEE_CA_BUNDLE env var)")
+	flag.IntVar(&maxRetries, "zone-api-max-retries", envInt("ZONEEE_API_MAX_RETRIES", DefaultRetryConfig.MaxRetries), "Retries for throttled (429), 5xx and network failures, 0 disables (or ZONEEE_API_MAX_RETRIES env var)")
+	flag.DurationVar(&retryBackoff, "zone-api-retry-backoff", envDuration("ZONEEE_API_RETRY_BACKOFF", DefaultRetryConfig.InitialBackoff), "Initial retry backoff, doubled on each attempt with jitter (or ZONEEE_API_RETRY_BACKOFF env var)")
+	flag.DurationVar(&retryMaxWait, "zone-api-retry-max-backoff", envDuration("ZONEEE_API_RETRY_MAX_BACKOFF", DefaultRetryConfig.MaxBackoff), "Upper bound for a single retry backoff (or ZONEEE_API_RETRY_MAX_BACKOFF env var)")
+	flag.Float64Var(&rateLimit, "zone-api-rate-limit", envFloat("ZONEEE_API_RATE_LIMIT", DefaultRateLimitConfig.RequestsPerSecond), "Fixed cap on Zone.ee API requests per second shared by all zones, 0 means no cap and only slow down when X-Ratelimit headers require it (or ZONEEE_API_RATE_LIMIT env var)")
+	flag.IntVar(&rateBurst, "zone-api-rate-burst", envInt("ZONEEE_API_RATE_BURST", DefaultRateLimitConfig.Burst), "Burst size of the Zone.ee API rate limiter (or ZONEEE_API_RATE_BURST env var)")
+	flag.DurationVar(&rateWindow, "zone-api-rate-window", envDuration("ZONEEE_API_RATE_WINDOW", DefaultRateLimitConfig.Window), "Window over which the remaining X-Ratelimit quota is spread when it runs low (or ZONEEE_API_RATE_WINDOW env var)")
+	flag.StringVar(&excludeDomain, "exclude-domains", os.Getenv("ZONEEE_EXCLUDE_DOMAINS"), "Comma separated domain suffixes to exclude (or ZONEEE_EXCLUDE_DOMAINS env var)")
+	flag.StringVar(&regexFilter, "regex-domain-filter", os.Getenv("ZONEEE_REGEX_DOMAIN_FILTER"), "Regular expression of domains to manage, overrides -domain-filter and -exclude-domains (or ZONEEE_REGEX_DOMAIN_FILTER env var)")
+	flag.StringVar(&regexExclude, "regex-domain-exclusion", os.Getenv("ZONEEE_REGEX_DOMAIN_EXCLUSION"), "Regular expression of domains to exclude, used with -regex-domain-filter (or ZONEEE_REGEX_DOMAIN_EXCLUSION env var)")
+	flag.DurationVar(&zoneRefresh, "zone-refresh-interval", envDuration("ZONEEE_ZONE_REFRESH_INTERVAL", DefaultZoneRefreshInterval), "How often the list of zones on the Zone.ee account is refreshed (or ZONEEE_ZONE_REFRESH_INTERVAL env var)")
+	flag.DurationVar(&cacheMaxAge, "records-cache-max-age", envDuration("ZONEEE_RECORDS_CACHE_MAX_AGE", DefaultRecordsCacheMaxAge), "How long a zone's record snapshot is served before it is listed again, 0 disables the cache (or ZONEEE_RECORDS_CACHE_MAX_AGE env var)")
+	flag.IntVar(&concurrency, "zone-api-concurrency", envInt("ZONEEE_API_CONCURRENCY", DefaultConcurrency), "Max concurrent Zone.ee API requests when listing zones and record types (or ZONEEE_API_CONCURRENCY env var)")
+	flag.DurationVar(&reqDeadline, "request-timeout", envDuration("ZONEEE_REQUEST_TIMEOUT", DefaultRequestDeadline), "Deadline for a single webhook request including all its Zone.ee calls, 0 disables (or ZONEEE_REQUEST_TIMEOUT env var)")
+	flag.DurationVar(&readTimeout, "server-read-timeout", envDuration("ZONEEE_SERVER_READ_TIMEOUT", defaultReadTimeout), "HTTP server read timeout (or ZONEEE_SERVER_READ_TIMEOUT env var)")
+	flag.DurationVar(&writeTimeout, "server-write-timeout", envDuration("ZONEEE_SERVER_WRITE_TIMEOUT", defaultWriteTimeout), "HTTP server write timeout, must exceed -request-timeout (or ZONEEE_SERVER_WRITE_TIMEOUT env var)")
+	flag.DurationVar(&idleTimeout, "server-idle-timeout", envDuration("ZONEEE_SERVER_IDLE_TIMEOUT", defaultIdleTimeout), "HTTP server keep-alive idle timeout (or ZONEEE_SERVER_IDLE_TIMEOUT env var)")
+	flag.DurationVar(&shutdownWait, "shutdown-timeout", envDuration("ZONEEE_SHUTDOWN_TIMEOUT", defaultShutdownTimeout), "How long to wait for in-flight requests on SIGTERM before exiting (or ZONEEE_SHUTDOWN_TIMEOUT env var)")
+	flag.StringVar(&logLevel, "log-level", envString("ZONEEE_LOG_LEVEL", "info"), "Log level: debug, info, warn or error (or ZONEEE_LOG_LEVEL env var)")
+	flag.StringVar(&logFormat, "log-format", envString("ZONEEE_LOG_FORMAT", "text"), "Log format: text or json (or ZONEEE_LOG_FORMAT env var)")
+	flag.StringVar(&logRedactTXT, "log-redact-txt", os.Getenv("ZONEEE_LOG_REDACT_TXT"), "Comma separated regular expressions of sensitive TXT values to redact from logs (or ZONEEE_LOG_REDACT_TXT env var)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("ZONEEE_OTLP_ENDPOINT"), "OTLP/HTTP collector URL for traces, e.g. http://otel-collector:4318; empty disables tracing (or ZONEEE_OTLP_ENDPOINT env var)")
+	flag.Float64Var(&traceSampling, "trace-sample-ratio", envFloat("ZONEEE_TRACE_SAMPLE_RATIO", DefaultTraceSampleRatio), "Fraction of root traces to sample, 0..1; the caller's sampling decision is always honoured (or ZONEEE_TRACE_SAMPLE_RATIO env var)")
+	flag.DurationVar(&accessCheck, "access-check-interval", envDuration("ZONEEE_ACCESS_CHECK_INTERVAL", DefaultAccessCheckInterval), "How often Zone.ee credentials and access to every managed zone are re-checked for /readyz (or ZONEEE_ACCESS_CHECK_INTERVAL env var)")
+	flag.StringVar(&authMode, "webhook-auth", envString("ZONEEE_WEBHOOK_AUTH", AuthModeNone), "Webhook API authentication: none, bearer or hmac (or ZONEEE_WEBHOOK_AUTH env var)")
+	flag.StringVar(&authSecret, "webhook-auth-secret-file", os.Getenv("ZONEEE_WEBHOOK_AUTH_SECRET_FILE"), "File with the shared bearer token or HMAC key, one per line; re-read when it changes (or ZONEEE_WEBHOOK_AUTH_SECRET_FILE env var)")
 	flag.Parse()
+}
 
-	if zoneUsername == "" || zoneApiKey == "" {
-		log.Fatal("ERROR: Zone.ee username and API key must be provided via flags or environment variables (ZONEEE_API_USER, ZONEEE_API_KEY)")
+// fatal logib vea ja lõpetab protsessi
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// splitList jagab komadega eraldatud nimekirja osadeks ja eemaldab tühjad elemendid
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			items = append(items, trimmed)
+		}
 	}
-	if domainFilter == "" {
-		log.Fatal("ERROR: Domain filter must be provided via -domain-filter flag or ZONEEE_DOMAIN_FILTER env var with specific zones")
+	return items
+}
+
+// envString tagastab keskkonnamuutuja väärtuse või vaikeväärtuse, kui see puudub
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
+	return def
 }
 
-func main() {
-	ctx := context.Background()
-
-	// Domeenifiltri loomine ja valideerimine (jääb samaks)
-	filters := strings.Split(domainFilter, ",")
-	validFilters := []string{}
-	for _, f := range filters {
-		trimmed := strings.TrimSpace(f)
-		if trimmed != "" {
-			validFilters = append(validFilters, trimmed)
-		}
+// envDuration loeb kestuse (nt "30s") keskkonnamuutujast, vigase või puuduva väärtuse korral tagastab vaikeväärtuse
+func envDuration(name string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
 	}
-	if len(validFilters) == 0 {
-		log.Fatal("ERROR: No valid zones found in domain filter after trimming")
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid duration value, using default", "env", name, "value", v, "default", def.String())
+		return def
 	}
-	df := endpoint.NewDomainFilter(validFilters)
+	return d
+}
 
-	// Zone provideri loomine (jääb samaks)
-	zoneProvider, err := NewZoneProvider(df, zoneUsername, zoneApiKey, dryRun)
+// envInt loeb täisarvu keskkonnamuutujast, vigase või puuduva väärtuse korral tagastab vaikeväärtuse
+func envInt(name string, def int) int {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to create Zone provider: %v", err)
+		slog.Warn("Invalid integer value, using default", "env", name, "value", v, "default", def)
+		return def
 	}
+	return i
+}
 
-	log.Printf("INFO: Starting Zone.ee ExternalDNS Webhook on %s", listenAddr)
-	if dryRun {
-		log.Println("INFO: Running in DRY RUN mode")
+// envFloat loeb ujukomaarvu keskkonnamuutujast, vigase või puuduva väärtuse korral tagastab vaikeväärtuse
+func envFloat(name string, def float64) float64 {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("Invalid number value, using default", "env", name, "value", v, "default", def)
+		return def
 	}
-	log.Printf("INFO: Managing zones: %v", df.Filters)
+	return f
+}
 
-	// --- HTTP Handlerid ---
+// envBool loeb boolean keskkonnamuutuja, vigase või puuduva väärtuse korral tagastab vaikeväärtuse
+func envBool(name string, def bool) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid boolean value, using default", "env", name, "value", v, "default", def)
+		return def
+	}
+	return b
+}
 
-	// Juurhandler (GET /) - Tagastab provideri võimekused (jääb samaks)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			log.Printf("WARN: Received non-GET request on /: %s", r.Method)
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		log.Println("INFO: Received GET request on /")
-		caps := Capabilities{
-			CanAdjustEndpoints: true,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(caps); err != nil {
-			log.Printf("ERROR: Failed to encode capabilities response: %v", err)
-		}
-	})
+func main() {
+	parseFlags()
 
-	// MUUDETUD: /records endpoint käsitleb nüüd nii GET (lugemine) kui POST (muudatuste rakendamine)
-	http.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			// GET /records: Tagastab olemasolevad kirjed
-			log.Println("INFO: Received GET request on /records")
-			endpoints, err := zoneProvider.Records(ctx)
-			if err != nil {
-				log.Printf("ERROR: Failed to get records: %v", err)
-				http.Error(w, "Failed to retrieve records: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(endpoints); err != nil {
-				log.Printf("ERROR: Failed to encode records response: %v", err)
-			}
-			log.Printf("INFO: Responded to GET /records with %d endpoints", len(endpoints))
-
-		case http.MethodPost:
-			// POST /records: Rakendab muudatused (ApplyChanges)
-			log.Println("INFO: Received POST request on /records (ApplyChanges)")
-			var changes plan.Changes
-			if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
-				log.Printf("ERROR: Failed to decode request body for POST /records: %v", err)
-				http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
-				return
-			}
+	// Logimine: tase ja formaat lippudest, API võti ja Authorization päised eemaldatakse alati
+	// Sama redaktor eemaldab saladused ka spanide veateadetest
+	redactor, err := newRedactor([]string{zoneApiKey}, splitList(logRedactTXT))
+	if err != nil {
+		fatal("Invalid logging configuration", logKeyError, err)
+	}
+	logger, err := newLogger(os.Stderr, LogConfig{Level: logLevel, Format: logFormat, Redactor: redactor})
+	if err != nil {
+		fatal("Invalid logging configuration", logKeyError, err)
+	}
+	slog.SetDefault(logger)
 
-			err := zoneProvider.ApplyChanges(ctx, &changes)
-			if err != nil {
-				log.Printf("ERROR: Failed to apply changes via POST /records: %v", err)
-				http.Error(w, "Failed to apply changes", http.StatusInternalServerError)
-				return
-			}
-			log.Println("INFO: Changes applied successfully via POST /records (or logged in dry-run)")
-			w.WriteHeader(http.StatusNoContent) // Edukas ApplyChanges tagastab 204
+	if zoneUsername == "" || zoneApiKey == "" {
+		fatal("Zone.ee username and API key must be provided via flags or environment variables (ZONEEE_API_USER, ZONEEE_API_KEY)")
+	}
 
-		default:
-			// Muud meetodid pole /records endpointil lubatud
-			log.Printf("WARN: Received unsupported method %s on /records", r.Method)
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	// SIGTERM (Kubernetes) või SIGINT alustab sujuvat seiskamist
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	// MUUDETUD: /adjustendpoints endpoint (POST) - Kohandab endpoint'e (AdjustEndpoints)
-	http.HandleFunc("/adjustendpoints", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			log.Printf("WARN: Received non-POST request on /adjustendpoints: %s", r.Method)
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		log.Println("INFO: Received POST request on /adjustendpoints")
+	// OTLP jälitamine, ilma -otlp-endpoint'ita jääb kasutusele no-op provider
+	shutdownTracing, err := setupTracing(ctx, TracingConfig{Endpoint: otlpEndpoint, SampleRatio: traceSampling, Redactor: redactor})
+	if err != nil {
+		fatal("Invalid tracing configuration", logKeyError, err)
+	}
 
-		var requestedEndpoints []*endpoint.Endpoint
-		if err := json.NewDecoder(r.Body).Decode(&requestedEndpoints); err != nil {
-			log.Printf("ERROR: Failed to decode request body for /adjustendpoints: %v", err)
-			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
-			return
+	// Domeenifiltri loomine external-dns reeglite järgi (sufiksid, välistused või regex).
+	// Hallatavad tsoonid leitakse Zone.ee kontolt ja ristatakse selle filtriga.
+	var df endpoint.DomainFilter
+	if regexFilter != "" || regexExclude != "" {
+		include, err := regexp.Compile(regexFilter)
+		if err != nil {
+			fatal("Invalid -regex-domain-filter", logKeyError, err)
 		}
-
-		adjustedEndpoints, err := zoneProvider.AdjustEndpoints(requestedEndpoints)
+		exclude, err := regexp.Compile(regexExclude)
 		if err != nil {
-			log.Printf("ERROR: Failed to adjust endpoints via /adjustendpoints: %v", err)
-			http.Error(w, "Failed to adjust endpoints: "+err.Error(), http.StatusInternalServerError)
-			return
+			fatal("Invalid -regex-domain-exclusion", logKeyError, err)
 		}
+		df = endpoint.NewRegexDomainFilter(include, exclude)
+	} else {
+		df = endpoint.NewDomainFilterWithExclusions(splitList(domainFilter), splitList(excludeDomain))
+	}
+	if !df.IsConfigured() {
+		slog.Info("No domain filter configured, managing every zone on the Zone.ee account")
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(adjustedEndpoints); err != nil {
-			log.Printf("ERROR: Failed to encode adjusted endpoints response: %v", err)
-		}
-		log.Printf("INFO: Responded to /adjustendpoints with %d adjusted endpoints", len(adjustedEndpoints))
+	// Zone.ee API transport (proxy, lisa-CA)
+	transport, err := NewZoneTransport(httpProxy, caBundle)
+	if err != nil {
+		fatal("Failed to configure HTTP transport", logKeyError, err)
+	}
+
+	// Zone provideri loomine
+	zoneProvider, err := NewZoneProvider(ZoneProviderConfig{
+		DomainFilter:        df,
+		Username:            zoneUsername,
+		APIKey:              zoneApiKey,
+		DryRun:              dryRun,
+		StrictRecords:       strictRecords,
+		ZoneRefreshInterval: zoneRefresh,
+		RecordsCacheMaxAge:  cacheMaxAge,
+		ClientOptions: []ZoneClientOption{
+			WithBaseURL(zoneAPIBase),
+			WithTimeout(zoneTimeout),
+			WithTransport(transport),
+			WithRetry(RetryConfig{
+				MaxRetries:     maxRetries,
+				InitialBackoff: retryBackoff,
+				MaxBackoff:     retryMaxWait,
+			}),
+			WithRateLimit(RateLimitConfig{
+				RequestsPerSecond: rateLimit,
+				Burst:             rateBurst,
+				Window:            rateWindow,
+			}),
+			WithConcurrency(concurrency),
+		},
 	})
+	if err != nil {
+		fatal("Failed to create Zone provider", logKeyError, err)
+	}
 
-	// EEMALDATUD: Vana /apply handler
-	// http.HandleFunc("/apply", ...)
+	slog.Info("Starting Zone.ee ExternalDNS Webhook", "listen_addr", listenAddr, "metrics_addr", metricsAddr, "dry_run", dryRun)
+	slog.Info("Domain filter", "include", df.Filters, "exclude", excludeDomain, "regex", regexFilter, "regex_exclusion", regexExclude, "zone_refresh_interval", zoneRefresh.String())
+	if otlpEndpoint != "" {
+		slog.Info("Tracing enabled", "otlp_endpoint", otlpEndpoint, "sample_ratio", traceSampling)
+	}
+	slog.Info("Zone.ee API", "url", zoneAPIBase, "timeout", zoneTimeout.String(), "rate_limit", rateLimit, "rate_burst", rateBurst, "concurrency", concurrency, "records_cache_max_age", cacheMaxAge.String())
 
-	// EEMALDATUD: Vana /adjust handler
-	// http.HandleFunc("/adjust", ...)
+	// --- HTTP Handlerid ---
 
-	// Tervisekontrolli endpoint (jääb samaks)
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "OK")
-	})
+	// Webhook protokolli handlerid (/, /records, /adjustendpoints) asuvad webhook.go failis
+	webhookMux := http.NewServeMux()
+	// Valikuline autentimine: ilma selleta võib iga pod, kes porti näeb, DNS kirjeid muuta
+	webhookAuth, err := NewWebhookAuth(authMode, authSecret)
+	if err != nil {
+		fatal("Invalid webhook authentication configuration", logKeyError, err)
+	}
+	if webhookAuth != nil {
+		slog.Info("Webhook API authentication enabled", "auth_mode", webhookAuth.Mode(), "secret_file", authSecret)
+	}
+	webhookServer := NewWebhookServer(zoneProvider, reqDeadline, webhookAuth)
+	webhookServer.RegisterHandlers(webhookMux)
+
+	// Halduse endpointid (/healthz, /readyz, /metrics) asuvad management.go failis.
+	// Eraldi kuulajal saab webhook API siduda loopbackiga (external-dns sidecar), proovid ja Prometheus
+	// kasutavad halduse porti. Kui -metrics-addr on tühi või sama, serveeritakse neid webhook pordil.
+	// /readyz on valmis alles siis, kui volitused ja kõik hallatavad tsoonid on kontrollitud
+	access := NewAccessCheck(zoneProvider.CheckAccess, accessCheck)
+	go access.Run(ctx)
+	managementServer := NewManagementServer(access)
+	var servers []*http.Server
+	if metricsAddr == "" || metricsAddr == listenAddr {
+		managementServer.RegisterHandlers(webhookMux)
+	} else {
+		managementMux := http.NewServeMux()
+		managementServer.RegisterHandlers(managementMux)
+		servers = append(servers, &http.Server{
+			Addr:              metricsAddr,
+			Handler:           managementMux,
+			ReadHeaderTimeout: readTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+		})
+	}
 
 	// --- Serveri Käivitamine ---
-	log.Printf("INFO: Starting server...")
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
-		log.Fatalf("ERROR: Failed to start HTTP server: %v", err)
+	if writeTimeout > 0 && reqDeadline > 0 && writeTimeout <= reqDeadline {
+		slog.Warn("-server-write-timeout is not longer than -request-timeout, slow responses may be cut off", "write_timeout", writeTimeout.String(), "request_timeout", reqDeadline.String())
+	}
+	// Webhook server on esimene, et seiskamisel lõpetataks kõigepealt pooleliolevad muudatused
+	servers = append([]*http.Server{{
+		Addr:              listenAddr,
+		Handler:           webhookMux,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}}, servers...)
+
+	serverErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			slog.Info("Starting server", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("%s: %w", server.Addr, err)
+			}
+		}()
+	}
+	managementServer.SetReady(true)
+
+	select {
+	case err := <-serverErr:
+		fatal("Failed to start HTTP server", logKeyError, err)
+	case <-ctx.Done():
+	}
+
+	// Shutdown ei võta uusi päringuid vastu ja ootab, kuni pooleliolevad (sh ApplyChanges) lõpevad.
+	// Päringute kontekste see ei tühista, seega käimasolev rakendamine ei katke poole pealt.
+	stop()
+	managementServer.SetReady(false)
+	slog.Info("Shutdown signal received, waiting for in-flight requests", "timeout", shutdownWait.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownWait)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Graceful shutdown did not complete", "addr", server.Addr, logKeyError, err)
+		}
+	}
+	// Pooleliolevate päringute spanid saadetakse enne väljumist kollektorile
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", logKeyError, err)
 	}
+	slog.Info("Server stopped")
 }
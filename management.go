@@ -0,0 +1,75 @@
+// Fail: management.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultMetricsAddr on halduse kuulaja vaikimisi aadress (external-dns webhookide tava)
+const DefaultMetricsAddr = ":8080"
+
+// ManagementServer pakub kubeleti ja Prometheuse jaoks /healthz, /readyz ja /metrics endpointe.
+// Need on webhook API-st eraldi, et muutvat POST /records endpointi ei peaks proovide jaoks avama.
+type ManagementServer struct {
+	ready  atomic.Bool
+	access *AccessCheck // nil = Zone.ee ligipääsu ei kontrollita
+}
+
+// NewManagementServer loob halduse serveri, mis on alguses mitte-valmis olekus.
+// Kui access on antud, on /readyz valmis ainult siis, kui viimane ligipääsu kontroll õnnestus.
+func NewManagementServer(access *AccessCheck) *ManagementServer {
+	return &ManagementServer{access: access}
+}
+
+// SetReady märgib, kas webhook võtab päringuid vastu (nt seiskamise ajal false)
+func (m *ManagementServer) SetReady(ready bool) {
+	m.ready.Store(ready)
+}
+
+// RegisterHandlers registreerib halduse endpointid antud mux'is
+func (m *ManagementServer) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", m.HealthzHandler)
+	mux.HandleFunc("/readyz", m.ReadyzHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// HealthzHandler (GET /healthz) - elavuse kontroll, vastab alati OK, kui protsess töötab
+func (m *ManagementServer) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "OK")
+}
+
+// ReadyzHandler (GET /readyz) - valmisoleku kontroll. Vastab 503 seiskamise ajal ning kuni Zone.ee
+// volitused ja tsoonid pole kontrollitud või viimane kontroll ebaõnnestus. Zone.ee poole päringuid
+// siin ei tehta, kasutatakse taustal tehtud kontrolli tulemust.
+func (m *ManagementServer) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.ready.Load() {
+		http.Error(w, "Not Ready", http.StatusServiceUnavailable)
+		return
+	}
+	if m.access != nil {
+		if checkedAt, err := m.access.Result(); err != nil {
+			message := fmt.Sprintf("Not Ready: %v", err)
+			if !checkedAt.IsZero() {
+				message += fmt.Sprintf(" (checked %s)", checkedAt.UTC().Format(time.RFC3339))
+			}
+			http.Error(w, message, http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,122 @@
+// Fail: metrics.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsNamespace on kõigi webhooki mõõdikute eesliide
+const metricsNamespace = "zoneee_webhook"
+
+var (
+	// recordsCallsTotal loeb kõiki Records kutseid, ka neid, mis said tulemuse jagatud päringust
+	recordsCallsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_calls_total",
+		Help:      "Number of Records calls (GET /records).",
+	})
+	// recordsDeduplicatedTotal loeb Records kutseid, mis ei teinud oma päringut, vaid ootasid samaaegse kutse tulemust
+	recordsDeduplicatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_deduplicated_total",
+		Help:      "Number of Records calls served by an in-flight fetch of another call for the same zone set.",
+	})
+)
+
+var (
+	// apiRequestsTotal ja apiRequestDuration mõõdavad iga Zone.ee API katset (ka kordusi)
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "Number of Zone.ee API requests by method, record type and status class.",
+	}, []string{"method", "record_type", "status_class"})
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of Zone.ee API requests by method, record type and status class.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "record_type", "status_class"})
+
+	// zoneRecordsGauge on Records poolt tagastatud (domeenifiltrile vastavate) kirjete arv tsoonis
+	zoneRecordsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_records",
+		Help:      "Number of managed records per zone as returned by the last Records call.",
+	}, []string{"zone"})
+
+	// applyOperationsTotal loeb ApplyChanges üksikuid kirjeoperatsioone tulemuse järgi
+	applyOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "apply_operations_total",
+		Help:      "Number of record operations performed by ApplyChanges by operation (create, update, delete) and result (success, failure).",
+	}, []string{"operation", "result"})
+	// applyTotal loeb ApplyChanges kutseid tulemuse järgi, sellele on mõistlik alert seada
+	applyTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "apply_total",
+		Help:      "Number of ApplyChanges calls by result (success, failure).",
+	}, []string{"result"})
+
+	// accessCheckGauge on 1, kui viimane Zone.ee volituste ja tsoonide ligipääsu kontroll õnnestus
+	accessCheckGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "access_check_ok",
+		Help:      "1 if the last Zone.ee credentials and zone access check succeeded, 0 otherwise.",
+	})
+
+	// authRejectedTotal loendab autentimata tagasi lükatud webhook päringuid
+	authRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_rejected_requests_total",
+		Help:      "Number of webhook requests rejected by authentication by auth mode and reason (missing, invalid, expired, error).",
+	}, []string{"mode", "reason"})
+
+	// lastSyncTimestamp on viimase vigadeta Zone.ee-st lugemise või eduka ApplyChanges kutse aeg.
+	// Ainult cache'ist vastatud Records kutse seda ei uuenda, sest Zone.ee-ga ei suheldud.
+	lastSyncTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last Records call that read Zone.ee without errors, or of the last successful ApplyChanges call.",
+	})
+)
+
+// metricRecordType tuletab API teekonnast kirjetüübi sildi: /dns/{tsoon}/{tüüp}[/{id}] -> tüüp, /dns -> "zone"
+func metricRecordType(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "dns" {
+		return strings.ToUpper(parts[2])
+	}
+	if len(parts) >= 1 && parts[0] == "dns" {
+		return "zone"
+	}
+	return "other"
+}
+
+// observeAPIRequest salvestab ühe API katse mõõdikud, resp on nil võrgu- või kontekstivea korral
+func observeAPIRequest(method, recordType string, resp *apiResponse, duration time.Duration) {
+	statusClass := "error"
+	if resp != nil {
+		statusClass = fmt.Sprintf("%dxx", resp.status/100)
+	}
+	apiRequestsTotal.WithLabelValues(method, recordType, statusClass).Inc()
+	apiRequestDuration.WithLabelValues(method, recordType, statusClass).Observe(duration.Seconds())
+}
+
+// recordApplyOperation salvestab ühe kirjeoperatsiooni tulemuse
+func recordApplyOperation(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	applyOperationsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// markSynced uuendab viimase eduka sünkroniseerimise aega
+func markSynced() {
+	lastSyncTimestamp.SetToCurrentTime()
+}
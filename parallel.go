@@ -0,0 +1,29 @@
+// Fail: parallel.go
+package main
+
+import "sync"
+
+// DefaultConcurrency on vaikimisi samaaegsete Zone.ee päringute arv
+const DefaultConcurrency = 4
+
+// forEachParallel käivitab fn iga indeksi 0..n-1 jaoks kuni limit töötajaga ja ootab kõigi lõpuni.
+// fn kirjutab oma tulemuse indeksi järgi, nii on järjekord deterministlik sõltumata lõpetamise ajast.
+func forEachParallel(n, limit int, fn func(i int)) {
+	limit = max(min(limit, n), 1)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
@@ -2,218 +2,634 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"slices"
+	"strconv" // Vajalik ID konvertimiseks
 	"strings"
-    "strconv" // Vajalik ID konvertimiseks
+	"time"
 
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 	//"sigs.k8s.io/external-dns/provider"
 )
 
+// ZoneProviderConfig koondab ZoneProvideri seadistuse
+type ZoneProviderConfig struct {
+	DomainFilter endpoint.DomainFilter
+	Username     string
+	APIKey       string
+	DryRun       bool
+	// StrictRecords korral tagastab Records vea, kui mõne tsooni või kirjetüübi listing ebaõnnestub.
+	// Muidu tagastatakse osaline vaade ja viga ainult logitakse.
+	StrictRecords bool
+	// ClientOptions edastatakse NewZoneClient'ile (API aadress, ajalimiit, transport)
+	ClientOptions []ZoneClientOption
+	// ZoneRefreshInterval määrab, kui tihti konto tsoonide nimekiri uuesti loetakse
+	ZoneRefreshInterval time.Duration
+	// RecordsCacheMaxAge määrab, kui kaua Records kasutab tsooni snapshotti, 0 lülitab cache'i välja
+	RecordsCacheMaxAge time.Duration
+}
+
 type ZoneProvider struct {
-	client      *ZoneClient
-	domainFilter endpoint.DomainFilter
-	dryRun      bool
+	client        *ZoneClient
+	domainFilter  endpoint.DomainFilter
+	dryRun        bool
+	strictRecords bool
+	records       *recordIndex // (nimi, tüüp, sihtmärk) -> Zone.ee ID, ühtlasi tsoonide snapshot cache
+	zones         *zoneList    // Konto tsoonid, mis vastavad domeenifiltrile
+	cacheMaxAge   time.Duration
+	recordsFlight recordsFlight // Samaaegsed Records kutsed sama tsoonide komplekti jaoks jagavad ühte päringut
 }
 
-func NewZoneProvider(domainFilter endpoint.DomainFilter, username, apiKey string, dryRun bool) (*ZoneProvider, error) {
-	client := NewZoneClient(username, apiKey)
+func NewZoneProvider(cfg ZoneProviderConfig) (*ZoneProvider, error) {
+	client := NewZoneClient(cfg.Username, cfg.APIKey, cfg.ClientOptions...)
 	return &ZoneProvider{
-		client:      client,
-		domainFilter: domainFilter,
-		dryRun:      dryRun,
+		client:        client,
+		domainFilter:  cfg.DomainFilter,
+		dryRun:        cfg.DryRun,
+		strictRecords: cfg.StrictRecords,
+		records:       newRecordIndex(),
+		zones:         newZoneList(client, cfg.DomainFilter, cfg.ZoneRefreshInterval),
+		cacheMaxAge:   cfg.RecordsCacheMaxAge,
 	}, nil
 }
 
-// Records kasutab nüüd GetZoneEndpoints, mis tagastab otse []*endpoint.Endpoint
+// Records hangib tsoonide kirjed, uuendab ID indeksit ja tagastab endpointid ilma Zone.ee ID-deta.
+// Strict režiimis muudab iga listingu viga kogu vastuse veaks, et external-dns ei tegutseks
+// kärbitud vaate põhjal (nt ei kustutaks TXT omanikukirjeid, mida ajutiselt ei õnnestunud lugeda).
 func (p *ZoneProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	// Käime läbi kõik konto tsoonid, mis vastavad domeenifiltrile
+	zones, err := p.zones.Zones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine managed zones: %w", err)
+	}
+	if len(zones) == 0 {
+		return nil, errNoZones
+	}
+
+	// Kattuvad kutsed (nt external-dns taaskäivitus või mitu replikat) ootavad juba käimasoleva
+	// päringu tulemust. Päring katkestatakse alles siis, kui kõik ootajad on lahkunud, ja selle
+	// ajalimiit on ootajate hiliseim ajalimiit.
+	recordsCallsTotal.Inc()
+	endpoints, shared, err := p.recordsFlight.Do(ctx, strings.Join(zones, ","), func(fetchCtx context.Context) ([]*endpoint.Endpoint, error) {
+		return p.fetchRecords(fetchCtx, zones)
+	})
+	if shared {
+		recordsDeduplicatedTotal.Inc()
+		logFrom(ctx).Info("Records call shared the result of an in-flight fetch", "zones", zones)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("waiting for records of zones %v: %w", zones, err)
+		}
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// fetchRecords loeb tsoonide kirjed (snapshotist või Zone.ee-st) ja koostab filtrile vastavad endpointid
+func (p *ZoneProvider) fetchRecords(ctx context.Context, zones []string) ([]*endpoint.Endpoint, error) {
 	var allEndpoints []*endpoint.Endpoint
+	var zoneErrors []error
+
+	// Tsoonid loetakse paralleelselt, tulemused pannakse kokku tsoonide sorteeritud järjekorras
+	zoneRecords := make([][]ZoneRecord, len(zones))
+	fromCache := make([]bool, len(zones))
+	fetchErrors := make([]error, len(zones))
+	forEachParallel(len(zones), p.client.concurrency, func(i int) {
+		zoneRecords[i], fromCache[i], fetchErrors[i] = p.cachedZone(ctx, zones[i])
+	})
+
+	for i, zoneName := range zones {
+		if err := fetchErrors[i]; err != nil {
+			// Logime vea ja jätkame teiste tsoonidega, strict režiimis tagastame lõpus koondvea
+			logFrom(ctx).Error("Failed to get zone records", logKeyZone, zoneName, logKeyError, err)
+			zoneErrors = append(zoneErrors, fmt.Errorf("zone %s: %w", zoneName, err))
+			if p.strictRecords {
+				continue
+			}
+		}
+		// Tsoon võib olla laiem kui filter (nt filter team-a.example.ee tsoonis example.ee),
+		// seega tagastame ainult filtrile vastavad nimed
+		zoneEndpoints := p.filterEndpoints(recordsToEndpoints(zoneRecords[i]))
+		logFrom(ctx).Info("Found manageable endpoints", logKeyZone, zoneName, "endpoints", len(zoneEndpoints))
+		if fetchErrors[i] == nil {
+			zoneRecordsGauge.WithLabelValues(zoneName).Set(float64(countTargets(zoneEndpoints)))
+		}
+		allEndpoints = append(allEndpoints, zoneEndpoints...)
+	}
+
+	if len(zoneErrors) > 0 {
+		if p.strictRecords {
+			return nil, &PartialRecordsError{Errors: zoneErrors}
+		}
+		logFrom(ctx).Warn("Returning partial record set, strict mode disabled", "failed_zones", len(zoneErrors))
+	}
 
-	// Käime läbi kõik domeenifiltri poolt lubatud tsoonid
-    // See eeldab, et filter sisaldab tsoone, mida hallata.
-    // Kui filter on nt `.example.com`, siis see ei tööta hästi.
-    // Eeldame, et filter sisaldab täpseid tsoone: `example.com,other.org`
-    if !p.domainFilter.IsConfigured() {
-         log.Println("WARN: Domain filter is not configured. Cannot determine zones to manage.")
-         return nil, fmt.Errorf("domain filter must be configured with specific zones")
-    }
-
-    for _, zoneName := range p.domainFilter.Filters {
-        log.Printf("INFO: Fetching records for zone %s", zoneName)
-        zoneEndpoints, err := p.client.GetZoneEndpoints(ctx, zoneName)
-        if err != nil {
-            // Logime vea, aga proovime teisi tsoone ka
-             log.Printf("ERROR: Failed to get records for zone %s: %v", zoneName, err)
-             continue
-             // Alternatiiv: tagasta kohe viga
-             // return nil, fmt.Errorf("failed to get records for zone %s: %w", zoneName, err)
-        }
-         log.Printf("INFO: Found %d manageable endpoints in zone %s", len(zoneEndpoints), zoneName)
-         allEndpoints = append(allEndpoints, zoneEndpoints...)
-    }
-
-
-    log.Printf("INFO: Returning %d total endpoints matching the filter", len(allEndpoints))
+	// Sünkroniseerimiseks loeme ainult Zone.ee-st värskelt loetud vastust, mitte cache'i kordamist
+	if len(zoneErrors) == 0 && slices.Contains(fromCache, false) {
+		markSynced()
+	}
+	logFrom(ctx).Info("Returning endpoints matching the filter", "endpoints", len(allEndpoints))
 	return allEndpoints, nil
 }
 
-// ApplyChanges rakendab muudatused (täiendatud MX/SRV jaoks)
+// countTargets loeb endpointide sihtmärgid kokku, iga sihtmärk on Zone.ee-s eraldi kirje
+func countTargets(endpoints []*endpoint.Endpoint) int {
+	n := 0
+	for _, ep := range endpoints {
+		n += len(ep.Targets)
+	}
+	return n
+}
+
+// filterEndpoints jätab alles ainult domeenifiltrile vastavate nimedega endpointid
+func (p *ZoneProvider) filterEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	filtered := endpoints[:0]
+	for _, ep := range endpoints {
+		if p.domainFilter.Match(ep.DNSName) {
+			filtered = append(filtered, ep)
+		}
+	}
+	return filtered
+}
+
+// PartialRecordsError tähendab, et vähemalt ühe tsooni või kirjetüübi listing ebaõnnestus.
+// Üksikud vead on *RecordListError tüüpi ja leitavad errors.As abil.
+type PartialRecordsError struct {
+	Errors []error
+}
+
+func (e *PartialRecordsError) Error() string {
+	return fmt.Sprintf("incomplete record listing: %v", errors.Join(e.Errors...))
+}
+
+func (e *PartialRecordsError) Unwrap() []error {
+	return e.Errors
+}
+
+// cachedZone tagastab tsooni kirjed snapshotist, kui see on piisavalt värske, muidu loeb need Zone.ee-st.
+// Teine tagastusväärtus näitab, kas kirjed tulid snapshotist.
+func (p *ZoneProvider) cachedZone(ctx context.Context, zoneName string) ([]ZoneRecord, bool, error) {
+	if p.cacheMaxAge > 0 {
+		if zoneRecords, ok := p.records.snapshot(zoneName, p.cacheMaxAge); ok {
+			logFrom(ctx).Debug("Using cached zone records", logKeyZone, zoneName, "records", len(zoneRecords))
+			return zoneRecords, true, nil
+		}
+	}
+	logFrom(ctx).Info("Fetching zone records", logKeyZone, zoneName)
+	zoneRecords, err := p.refreshZone(ctx, zoneName)
+	return zoneRecords, false, err
+}
+
+// refreshZone hangib tsooni värske listingu ja ehitab selle põhjal ID indeksi uuesti.
+// Osalise listingu korral tagastatakse leitud kirjed koos veaga ja tsoon visatakse cache'ist välja.
+// Kui listingu ajal kirjutati tsooni, jääb indeksisse kirjutuse järgne seis ja listing jäetakse kõrvale.
+func (p *ZoneProvider) refreshZone(ctx context.Context, zoneName string) ([]ZoneRecord, error) {
+	generation := p.records.generation(zoneName)
+	zoneRecords, err := p.client.GetZoneRecords(ctx, zoneName)
+	if err != nil {
+		p.records.evict(zoneName)
+		return zoneRecords, err
+	}
+	if !p.records.replaceZone(zoneName, zoneRecords, generation) {
+		logFrom(ctx).Info("Discarding zone listing that started before a concurrent write", logKeyZone, zoneName)
+	}
+	return zoneRecords, nil
+}
+
+// CheckAccess kontrollib, kas volitused kehtivad ja iga hallatav tsoon on loetav. Tsoonid loetakse
+// kontolt uuesti ja vea korral eelmist nimekirja ei kasutata, kuid Records'i kasutatavat nimekirja
+// ei asendata, see uueneb endiselt -zone-refresh-interval järgi. Kasutatakse valmisoleku kontrollis.
+func (p *ZoneProvider) CheckAccess(ctx context.Context) error {
+	zones, err := p.zones.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		return errNoZones
+	}
+	if unmatched := p.zones.unmatchedFilters(zones); len(unmatched) > 0 {
+		return fmt.Errorf("domain filter %v matches no zone on the Zone.ee account", unmatched)
+	}
+
+	errs := make([]error, len(zones))
+	forEachParallel(len(zones), p.client.concurrency, func(i int) {
+		errs[i] = p.client.CheckZoneAccess(ctx, zones[i])
+	})
+	return errors.Join(errs...)
+}
+
+// recordResolver leiab uuenduste ja kustutuste jaoks Zone.ee kirjed tsooni elava listingu põhjal.
+// Iga tsoon loetakse ühe ApplyChanges kutse jooksul üks kord, seega ei sõltu me eelmise
+// Records kutse seisust ega external-dns poolt saadetud ID-dest.
+type recordResolver struct {
+	p       *ZoneProvider
+	fetched map[string]error // tsoon -> listingu viga (nil = edukalt loetud)
+}
+
+// newRecordResolver loob resolveri ühe ApplyChanges kutse jaoks
+func (p *ZoneProvider) newRecordResolver() *recordResolver {
+	return &recordResolver{p: p, fetched: make(map[string]error)}
+}
+
+// resolve leiab nime, tüübi ja sihtmärgi järgi täpselt ühe Zone.ee kirje ja selle numbrilise ID.
+// Kui kirjet pole või neid on mitu, tagastatakse selge viga.
+func (r *recordResolver) resolve(ctx context.Context, zoneName string, ep *endpoint.Endpoint, target string) (ZoneRecord, int, error) {
+	err, ok := r.fetched[zoneName]
+	// Tsoon võidi vahepeal mõne ebaõnnestunud kirjutuse tõttu cache'ist välja visata, siis loeme uuesti
+	if !ok || (err == nil && !r.p.records.has(zoneName)) {
+		logFrom(ctx).Info("Loading live zone records to resolve record IDs", logKeyZone, zoneName)
+		_, err = r.p.refreshZone(ctx, zoneName)
+		if err == nil && !r.p.records.has(zoneName) {
+			// Listing jäeti samaaegse kirjutuse tõttu kõrvale, järgmine resolve proovib uuesti
+			return ZoneRecord{}, 0, fmt.Errorf("zone %s changed while it was being listed", zoneName)
+		}
+		r.fetched[zoneName] = err
+	}
+	if err != nil {
+		return ZoneRecord{}, 0, fmt.Errorf("failed to list zone %s: %w", zoneName, err)
+	}
+
+	matches := r.p.records.lookup(zoneName, newRecordKey(ep.DNSName, ep.RecordType, target))
+	switch len(matches) {
+	case 0:
+		return ZoneRecord{}, 0, fmt.Errorf("no Zone.ee record found for %s %s %s in zone %s", ep.DNSName, ep.RecordType, target, zoneName)
+	case 1:
+	default:
+		ids := make([]string, len(matches))
+		for i, m := range matches {
+			ids[i] = m.ID
+		}
+		return ZoneRecord{}, 0, fmt.Errorf("ambiguous match for %s %s %s in zone %s: %d records (IDs: %s)", ep.DNSName, ep.RecordType, target, zoneName, len(matches), strings.Join(ids, ", "))
+	}
+
+	record := matches[0]
+	recordID, err := strconv.Atoi(record.ID) // Kasuta strconv.Atoi
+	if err != nil {
+		return ZoneRecord{}, 0, fmt.Errorf("invalid record ID format '%s' for %s %s: %w", record.ID, ep.DNSName, ep.RecordType, err)
+	}
+	return record, recordID, nil
+}
+
+// withTarget tagastab endpointi koopia ühe sihtmärgiga. Zone.ee kirjel on alati üks sihtmärk,
+// seega mitme sihtmärgiga endpoint rakendatakse mitme kirjena.
+func withTarget(ep *endpoint.Endpoint, target string) *endpoint.Endpoint {
+	single := ep.DeepCopy()
+	single.Targets = endpoint.Targets{target}
+	return single
+}
+
+// diffTargets võrdleb vana ja uut sihtmärkide komplekti normaliseeritud kujul ning tagastab
+// eemaldatud ja lisatud sihtmärgid (algses järjekorras). Ühised sihtmärgid jäetakse puutumata.
+func diffTargets(recordType string, oldTargets, newTargets []string) (removed, added []string) {
+	oldSet := make(map[string]bool, len(oldTargets))
+	for _, t := range oldTargets {
+		oldSet[newRecordKey("", recordType, t).Target] = true
+	}
+	newSet := make(map[string]bool, len(newTargets))
+	for _, t := range newTargets {
+		norm := newRecordKey("", recordType, t).Target
+		if !newSet[norm] && !oldSet[norm] {
+			added = append(added, t)
+		}
+		newSet[norm] = true
+	}
+	for _, t := range oldTargets {
+		norm := newRecordKey("", recordType, t).Target
+		if !newSet[norm] {
+			removed = append(removed, t)
+			newSet[norm] = true // Duplikaatide vältimiseks
+		}
+	}
+	return removed, added
+}
+
+// createTarget loob ühe sihtmärgiga Zone.ee kirje
+func (p *ZoneProvider) createTarget(ctx context.Context, zoneName string, ep *endpoint.Endpoint, target string) error {
+	if err := checkNSGuard(zoneName, ep, nil, "create"); err != nil {
+		return err
+	}
+	logger := logFrom(ctx).With(logKeyZone, zoneName, logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyTarget, target)
+	logger.Info("Creating record")
+	created, err := p.client.CreateRecord(ctx, zoneName, withTarget(ep, target))
+	if err != nil {
+		// Kirje olek Zone.ee-s pole teada (dubleeritud kirje ID-d me ei saanud), järgmine Records loeb tsooni uuesti
+		p.records.evict(zoneName)
+		var apiErr *ZoneAPIError
+		if errors.As(err, &apiErr) && apiErr.IsDuplicate() {
+			// Soovitud olek on juba olemas (nt eelmine katse õnnestus), see pole viga
+			logger.Info("Record already exists, treating create as success")
+			return nil
+		}
+		return fmt.Errorf("failed to create record %s %s %s: %w", ep.DNSName, ep.RecordType, target, err)
+	}
+	p.records.upsert(zoneName, created)
+	if len(created) > 0 {
+		logger = logger.With(logKeyRecordID, created[0].ID)
+	}
+	logger.Info("Created record")
+	return nil
+}
+
+// updateTarget asendab olemasoleva kirje sihtmärgi oldTarget uue sihtmärgiga newTarget (PUT)
+func (p *ZoneProvider) updateTarget(ctx context.Context, resolver *recordResolver, zoneName string, epOld, epNew *endpoint.Endpoint, oldTarget, newTarget string) error {
+	record, recordID, err := resolver.resolve(ctx, zoneName, epOld, oldTarget)
+	if err != nil {
+		return fmt.Errorf("could not resolve record ID for updating %s %s: %w", epNew.DNSName, epNew.RecordType, err)
+	}
+	if err := checkNSGuard(zoneName, epNew, &record, "update"); err != nil {
+		return err
+	}
+	logger := logFrom(ctx).With(logKeyZone, zoneName, logKeyName, epNew.DNSName, logKeyRecordType, epNew.RecordType, logKeyRecordID, record.ID)
+	logger.Info("Updating record", "old_target", oldTarget, logKeyTarget, newTarget)
+	updated, err := p.client.UpdateRecord(ctx, zoneName, recordID, withTarget(epNew, newTarget))
+	if err != nil {
+		p.records.evict(zoneName)
+		return fmt.Errorf("failed to update record %s %s (ID: %d): %w", epNew.DNSName, epNew.RecordType, recordID, err)
+	}
+	p.records.upsert(zoneName, updated)
+	logger.Info("Updated record")
+	return nil
+}
+
+// deleteTarget kustutab ühe sihtmärgiga Zone.ee kirje
+func (p *ZoneProvider) deleteTarget(ctx context.Context, resolver *recordResolver, zoneName string, ep *endpoint.Endpoint, target string) error {
+	record, recordID, err := resolver.resolve(ctx, zoneName, ep, target)
+	if err != nil {
+		return fmt.Errorf("could not resolve record ID for deleting %s %s: %w", ep.DNSName, ep.RecordType, err)
+	}
+	if err := checkNSGuard(zoneName, ep, &record, "delete"); err != nil {
+		return err
+	}
+	logger := logFrom(ctx).With(logKeyZone, zoneName, logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyRecordID, record.ID, logKeyTarget, target)
+	logger.Info("Deleting record")
+	if err := p.client.DeleteRecord(ctx, zoneName, ep.RecordType, recordID); err != nil {
+		var apiErr *ZoneAPIError
+		if errors.As(err, &apiErr) && apiErr.IsNotFound() {
+			// Kirje on juba kustutatud, soovitud olek on saavutatud
+			logger.Info("Record already gone, treating delete as success")
+			p.records.remove(zoneName, record.RecordType, record.ID)
+			return nil
+		}
+		p.records.evict(zoneName)
+		return fmt.Errorf("failed to delete record %s %s (ID: %d): %w", ep.DNSName, ep.RecordType, recordID, err)
+	}
+	p.records.remove(zoneName, record.RecordType, record.ID)
+	logger.Info("Deleted record")
+	return nil
+}
+
+// isZoneApex kontrollib, kas nimi on tsooni tipp (nt example.ee tsoonis example.ee)
+func isZoneApex(name, zoneName string) bool {
+	return strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(zoneName, "."))
+}
+
+// validateChanges kontrollib muudatuste kuju enne rakendamist: UpdateOld ja UpdateNew on paarid
+// (sama indeks) ja ükski endpoint ei tohi puududa
+func validateChanges(changes *plan.Changes) error {
+	if changes == nil {
+		return &InvalidChangesError{Reason: "changes are missing"}
+	}
+	if len(changes.UpdateOld) != len(changes.UpdateNew) {
+		return &InvalidChangesError{Reason: fmt.Sprintf("UpdateOld has %d endpoints but UpdateNew has %d, they must be pairs", len(changes.UpdateOld), len(changes.UpdateNew))}
+	}
+	lists := []struct {
+		name      string
+		endpoints []*endpoint.Endpoint
+	}{
+		{"Create", changes.Create}, {"UpdateOld", changes.UpdateOld}, {"UpdateNew", changes.UpdateNew}, {"Delete", changes.Delete},
+	}
+	for _, list := range lists {
+		for i, ep := range list.endpoints {
+			if ep == nil {
+				return &InvalidChangesError{Reason: fmt.Sprintf("%s[%d] is null", list.name, i)}
+			}
+		}
+	}
+	return nil
+}
+
+// checkNSGuard keelab tsooni tipu NS komplekti muutmise, mida haldab Zone.ee ise.
+// Olemasoleva kirje puhul austatakse ka Zone.ee poolt tagastatud modify/delete õigusi.
+// record on nil loomise korral.
+func checkNSGuard(zoneName string, ep *endpoint.Endpoint, record *ZoneRecord, op string) error {
+	if !strings.EqualFold(ep.RecordType, endpoint.RecordTypeNS) {
+		return nil
+	}
+	if isZoneApex(ep.DNSName, zoneName) {
+		return fmt.Errorf("refusing to %s zone apex NS record %s: the apex NS set is managed by Zone.ee", op, ep.DNSName)
+	}
+	if record == nil {
+		return nil
+	}
+	if op == "update" && !record.CanModify {
+		return fmt.Errorf("refusing to update NS record %s (ID: %s): Zone.ee does not allow modifying it", ep.DNSName, record.ID)
+	}
+	if op == "delete" && !record.CanDelete {
+		return fmt.Errorf("refusing to delete NS record %s (ID: %s): Zone.ee does not allow deleting it", ep.DNSName, record.ID)
+	}
+	return nil
+}
+
+// ApplyChanges rakendab muudatused ja salvestab tulemuse mõõdikutesse
 func (p *ZoneProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	if err := p.applyChanges(ctx, changes); err != nil {
+		applyTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	applyTotal.WithLabelValues("success").Inc()
+	markSynced()
+	return nil
+}
+
+// applyChanges rakendab muudatused (täiendatud MX/SRV jaoks)
+func (p *ZoneProvider) applyChanges(ctx context.Context, changes *plan.Changes) error {
+	if err := validateChanges(changes); err != nil {
+		return err
+	}
+	zones, err := p.zones.Zones(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to determine managed zones: %w", err)
+	}
+
 	if p.dryRun {
-		log.Println("INFO: Dry run mode enabled. Skipping actual changes.")
+		logger := logFrom(ctx)
+		logger.Info("Dry run mode enabled, skipping actual changes")
+		zoneOf := func(ep *endpoint.Endpoint) string {
+			if !p.domainFilter.Match(ep.DNSName) {
+				return "none, outside domain filter"
+			}
+			if zoneName := p.getZoneNameFromEndpoint(zones, ep); zoneName != "" {
+				return zoneName
+			}
+			return "none, would be rejected"
+		}
 		for _, ep := range changes.Create {
-            log.Printf("DRY-RUN: CREATE %s %s %s (Zone: %s)", ep.DNSName, ep.RecordType, ep.Targets, p.getZoneNameFromEndpoint(ep))
-        }
-        for _, ep := range changes.UpdateNew {
-             log.Printf("DRY-RUN: UPDATE %s %s %s (Zone: %s, ID: %s)", ep.DNSName, ep.RecordType, ep.Targets, p.getZoneNameFromEndpoint(ep), ep.SetIdentifier)
-        }
-        for _, ep := range changes.Delete {
-             log.Printf("DRY-RUN: DELETE %s %s (Zone: %s, ID: %s)", ep.DNSName, ep.RecordType, p.getZoneNameFromEndpoint(ep), ep.SetIdentifier)
-        }
+			logger.Info("Dry run: create", logKeyZone, zoneOf(ep), logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, "targets", ep.Targets)
+		}
+		for i, ep := range changes.UpdateNew {
+			logger.Info("Dry run: update", logKeyZone, zoneOf(ep), logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, "old_targets", changes.UpdateOld[i].Targets, "targets", ep.Targets)
+		}
+		for _, ep := range changes.Delete {
+			logger.Info("Dry run: delete", logKeyZone, zoneOf(ep), logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, "targets", ep.Targets)
+		}
 		return nil
 	}
 
-    log.Printf("INFO: Applying changes: Creates=%d, Updates=%d, Deletes=%d", len(changes.Create), len(changes.UpdateNew), len(changes.Delete))
-    var applyErrors []error // Kogume vead kokku
+	logFrom(ctx).Info("Applying changes", "creates", len(changes.Create), "updates", len(changes.UpdateNew), "deletes", len(changes.Delete))
+	var applyErrors []error // Kogume vead kokku
 
-	// Loome kirjed
-	for _, ep := range changes.Create {
-        zoneName := p.getZoneNameFromEndpoint(ep)
-        if zoneName == "" {
-            msg := fmt.Sprintf("WARN: Could not determine zone name for creating %s %s. Skipping.", ep.RecordType, ep.DNSName)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-            continue
-        }
-		log.Printf("INFO: Creating record %s %s %s in zone %s", ep.DNSName, ep.RecordType, ep.Targets, zoneName)
-
-		err := p.client.CreateRecord(ctx, zoneName, ep) // Kasutame uut client meetodit
+	// Uuenduste ja kustutuste ID-d leitakse tsoonide elava listingu põhjal
+	resolver := p.newRecordResolver()
+
+	// run käivitab ühe kirje operatsiooni, logib vea ja lisab selle koondvea hulka.
+	// Vigaste volituste korral (401/403) on iga järgmine päring samuti määratud ebaõnnestuma,
+	// seega katkestame rakendamise ja ülejäänud operatsioonid jäetakse vahele.
+	aborted := false
+	run := func(operation string, op func() error) {
+		if aborted {
+			return
+		}
+		err := op()
+		recordApplyOperation(operation, err)
+		if err == nil {
+			return
+		}
+		logFrom(ctx).Error("Record operation failed", "operation", operation, logKeyError, err)
+		applyErrors = append(applyErrors, err)
+		if isUnauthorized(err) {
+			logFrom(ctx).Error("Zone.ee rejected the API credentials, aborting apply. Check ZONEEE_API_USER and ZONEEE_API_KEY.")
+			aborted = true
+		}
+	}
+
+	// zoneFor leiab endpointi tsooni. Endpoint, mis jääb domeenifiltrist välja või ei kuulu ühtegi
+	// hallatavasse tsooni, lükatakse tagasi ja tühi string tähendab, et see tuleb vahele jätta.
+	zoneFor := func(op string, ep *endpoint.Endpoint) string {
+		var err error
+		zoneName := ""
+		if !p.domainFilter.Match(ep.DNSName) {
+			err = &DomainFilterError{Op: op, DNSName: ep.DNSName, RecordType: ep.RecordType}
+		} else if zoneName = p.getZoneNameFromEndpoint(zones, ep); zoneName == "" {
+			err = &ZoneMatchError{Op: op, DNSName: ep.DNSName, RecordType: ep.RecordType, Zones: zones}
+		}
 		if err != nil {
-			msg := fmt.Sprintf("ERROR: Failed to create record %s %s: %v", ep.DNSName, ep.RecordType, err)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-		} else {
-            log.Printf("SUCCESS: Created record %s %s", ep.DNSName, ep.RecordType)
-        }
+			logFrom(ctx).Warn("Rejected change", "operation", op, logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyError, err)
+			recordApplyOperation(op, err)
+			applyErrors = append(applyErrors, err)
+		}
+		return zoneName
+	}
+
+	// Loome kirjed, iga sihtmärk on eraldi Zone.ee kirje
+	for _, ep := range changes.Create {
+		zoneName := zoneFor("create", ep)
+		if zoneName == "" {
+			continue
+		}
+		for _, target := range ep.Targets {
+			run("create", func() error { return p.createTarget(ctx, zoneName, ep, target) })
+		}
 	}
 
-	// Uuendame kirjed
+	// Uuendame kirjed: võrdleme sihtmärkide komplekte ja teeme ainult vajalikud muudatused
 	for i, epNew := range changes.UpdateNew {
-		_ = changes.UpdateOld[i] // Vana kirje info
-
-        zoneName := p.getZoneNameFromEndpoint(epNew)
-         if zoneName == "" {
-            msg := fmt.Sprintf("WARN: Could not determine zone name for updating %s %s. Skipping.", epNew.RecordType, epNew.DNSName)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-            continue
-        }
-
-        recordIDStr := epNew.SetIdentifier
-        if recordIDStr == "" {
-             msg := fmt.Sprintf("ERROR: Missing record ID (SetIdentifier) for updating %s %s. Skipping.", epNew.DNSName, epNew.RecordType)
-             log.Println(msg)
-             applyErrors = append(applyErrors, fmt.Errorf(msg))
-            continue
-        }
-        recordID, err := strconv.Atoi(recordIDStr) // Kasuta strconv.Atoi
-        if err != nil {
-             msg := fmt.Sprintf("ERROR: Invalid record ID format '%s' for updating %s %s: %v. Skipping.", recordIDStr, epNew.DNSName, epNew.RecordType, err)
-             log.Println(msg)
-             applyErrors = append(applyErrors, fmt.Errorf(msg))
-             continue
-        }
-
-		log.Printf("INFO: Updating record %s %s (ID: %d) in zone %s to target %s", epNew.DNSName, epNew.RecordType, recordID, zoneName, epNew.Targets)
-
-		err = p.client.UpdateRecord(ctx, zoneName, recordID, epNew) // Kasutame uut client meetodit
-		if err != nil {
-			msg := fmt.Sprintf("ERROR: Failed to update record %s %s (ID: %d): %v", epNew.DNSName, epNew.RecordType, recordID, err)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-		} else {
-             log.Printf("SUCCESS: Updated record %s %s (ID: %d)", epNew.DNSName, epNew.RecordType, recordID)
-        }
+		epOld := changes.UpdateOld[i] // Vana kirje järgi leiame Zone.ee ID-d
+
+		zoneName := zoneFor("update", epNew)
+		if zoneName == "" {
+			continue
+		}
+
+		removed, added := diffTargets(epNew.RecordType, epOld.Targets, epNew.Targets)
+		if len(removed) == 0 && len(added) == 0 {
+			logFrom(ctx).Info("Targets unchanged, nothing to update", logKeyZone, zoneName, logKeyName, epNew.DNSName, logKeyRecordType, epNew.RecordType)
+			continue
+		}
+		// Eemaldatud ja lisatud sihtmärgid paaritame PUT päringuteks, ülejäänud on loomised või kustutamised
+		for len(removed) > 0 && len(added) > 0 {
+			oldTarget, newTarget := removed[0], added[0]
+			run("update", func() error { return p.updateTarget(ctx, resolver, zoneName, epOld, epNew, oldTarget, newTarget) })
+			removed, added = removed[1:], added[1:]
+		}
+		for _, target := range added {
+			run("create", func() error { return p.createTarget(ctx, zoneName, epNew, target) })
+		}
+		for _, target := range removed {
+			run("delete", func() error { return p.deleteTarget(ctx, resolver, zoneName, epOld, target) })
+		}
 	}
 
-	// Kustutame kirjed
+	// Kustutame kirjed, iga sihtmärk eraldi
 	for _, ep := range changes.Delete {
-        zoneName := p.getZoneNameFromEndpoint(ep)
-        if zoneName == "" {
-            msg := fmt.Sprintf("WARN: Could not determine zone name for deleting %s %s. Skipping.", ep.RecordType, ep.DNSName)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-            continue
-        }
-
-        recordIDStr := ep.SetIdentifier
-        if recordIDStr == "" {
-             msg := fmt.Sprintf("ERROR: Missing record ID (SetIdentifier) for deleting %s %s. Skipping.", ep.DNSName, ep.RecordType)
-             log.Println(msg)
-             applyErrors = append(applyErrors, fmt.Errorf(msg))
-            continue
-        }
-        recordID, err := strconv.Atoi(recordIDStr) // Kasuta strconv.Atoi
-        if err != nil {
-            msg := fmt.Sprintf("ERROR: Invalid record ID format '%s' for deleting %s %s: %v. Skipping.", recordIDStr, ep.DNSName, ep.RecordType, err)
-            log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-             continue
-        }
-
-		log.Printf("INFO: Deleting record %s %s (ID: %d) from zone %s", ep.DNSName, ep.RecordType, recordID, zoneName)
-		err = p.client.DeleteRecord(ctx, zoneName, ep.RecordType, recordID) // recordType on juba string
-		if err != nil {
-            msg := fmt.Sprintf("ERROR: Failed to delete record %s %s (ID: %d): %v", ep.DNSName, ep.RecordType, recordID, err)
-			log.Println(msg)
-            applyErrors = append(applyErrors, fmt.Errorf(msg))
-		} else {
-             log.Printf("SUCCESS: Deleted record %s %s (ID: %d)", ep.DNSName, ep.RecordType, recordID)
-        }
+		zoneName := zoneFor("delete", ep)
+		if zoneName == "" {
+			continue
+		}
+		for _, target := range ep.Targets {
+			run("delete", func() error { return p.deleteTarget(ctx, resolver, zoneName, ep, target) })
+		}
 	}
 
 	// Tagasta koondviga, kui mõni operatsioon ebaõnnestus
-    if len(applyErrors) > 0 {
-        // Koosta vigadest üks string
-        errorMessages := make([]string, len(applyErrors))
-        for i, err := range applyErrors {
-            errorMessages[i] = err.Error()
-        }
-        return fmt.Errorf("encountered %d error(s) during apply changes: %s", len(applyErrors), strings.Join(errorMessages, "; "))
-    }
+	if len(applyErrors) > 0 {
+		return &ApplyError{Errors: applyErrors, Aborted: aborted}
+	}
 
 	return nil
 }
 
-// getZoneNameFromEndpoint (JÄÄB SAMAKS) - Eeldab, et domainFilter sisaldab tsoone.
-func (p *ZoneProvider) getZoneNameFromEndpoint(ep *endpoint.Endpoint) string {
-    for _, zone := range p.domainFilter.Filters {
-        // Eemaldame lõpust punkti, kui see on olemas nii nimes kui tsoonis
-        dnsNameTrimmed := strings.TrimSuffix(ep.DNSName, ".")
-        zoneTrimmed := strings.TrimSuffix(zone, ".")
-        if dnsNameTrimmed == zoneTrimmed || strings.HasSuffix(dnsNameTrimmed, "."+zoneTrimmed) {
-            return zone // Tagastame originaal tsooni nime filtrist
-        }
-    }
-    log.Printf("WARN: Could not determine zone for endpoint %s using domain filter %v", ep.DNSName, p.domainFilter.Filters)
-    // Kui filter on täpselt üks tsoon, võime selle tagastada fallbackina?
-    if len(p.domainFilter.Filters) == 1 {
-        log.Printf("DEBUG: Falling back to single zone in filter: %s", p.domainFilter.Filters[0])
-        return p.domainFilter.Filters[0]
-    }
-    return ""
-}
-
-
-// AdjustEndpoints (JÄÄB SAMAKS)
-func (p *ZoneProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+// getZoneNameFromEndpoint leiab endpointile tsooni hallatavate tsoonide hulgast pikima sobiva sufiksi järgi,
+// nii et sub.example.ee kirjed lähevad sub.example.ee tsooni, mitte example.ee tsooni. Võrdlus ei arvesta
+// suur- ja väiketähti ega lõpupunkti. Kui ükski tsoon ei sobi, tagastatakse tühi string.
+func (p *ZoneProvider) getZoneNameFromEndpoint(zones []string, ep *endpoint.Endpoint) string {
+	name := strings.ToLower(strings.TrimSuffix(ep.DNSName, "."))
+	best := ""
+	for _, zone := range zones {
+		zoneTrimmed := strings.ToLower(strings.TrimSuffix(zone, "."))
+		if name != zoneTrimmed && !strings.HasSuffix(name, "."+zoneTrimmed) {
+			continue
+		}
+		if len(zoneTrimmed) > len(best) {
+			best = zoneTrimmed
+		}
+	}
+	return best
+}
+
+// AdjustEndpoints viib soovitud endpointid samale kujule, mida Records tagastab,
+// et external-dns ei näeks võrdlemisel näilisi erinevusi (nt AAAA aadresside või CAA jutumärkide kirjapilt).
+// Erinevalt external-dns provider liidesest võtab see konteksti, et hoiatused kannaksid päringu logivälju.
+func (p *ZoneProvider) AdjustEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	for _, ep := range endpoints {
+		for i, target := range ep.Targets {
+			var canonical string
+			var err error
+			switch ep.RecordType {
+			case endpoint.RecordTypeAAAA:
+				canonical, err = canonicalIPv6(target)
+			case "CAA":
+				var flag int
+				var tag, value string
+				flag, tag, value, err = parseCAATarget(target)
+				canonical = formatCAATarget(flag, tag, value)
+			default:
+				continue
+			}
+			if err != nil {
+				// Jätame muutmata, CreateRecord/UpdateRecord annavad selge vea
+				logFrom(ctx).Warn("Invalid target", logKeyName, ep.DNSName, logKeyRecordType, ep.RecordType, logKeyTarget, target, logKeyError, err)
+				continue
+			}
+			ep.Targets[i] = canonical
+		}
+	}
 	return endpoints, nil
 }
 
 // GetDomainFilter (JÄÄB SAMAKS)
 func (p *ZoneProvider) GetDomainFilter() endpoint.DomainFilter {
-    return p.domainFilter
+	return p.domainFilter
 }
-
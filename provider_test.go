@@ -0,0 +1,200 @@
+// Fail: provider_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// newTestProvider loob provideri, mis suhtleb antud Zone.ee asendusega. Testide ühine alus, ka webhooki
+// testid ehitatakse selle peale. Domeenifilter on vaikimisi example.ee.
+func newTestProvider(t *testing.T, api http.Handler, cfg ZoneProviderConfig) *ZoneProvider {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	if !cfg.DomainFilter.IsConfigured() {
+		cfg.DomainFilter = endpoint.NewDomainFilter([]string{"example.ee"})
+	}
+	cfg.Username, cfg.APIKey = "user", "key"
+	cfg.ClientOptions = append(cfg.ClientOptions, WithBaseURL(server.URL), WithRetry(RetryConfig{}))
+	provider, err := NewZoneProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewZoneProvider: %v", err)
+	}
+	return provider
+}
+
+// emptyZoneAPI tagastab ühe tühja tsooni example.ee
+func emptyZoneAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dns" {
+			w.Write([]byte(`[{"name":"example.ee"}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+}
+
+func TestRecordsFromCacheDoNotMarkSync(t *testing.T) {
+	provider := newTestProvider(t, emptyZoneAPI(), ZoneProviderConfig{RecordsCacheMaxAge: time.Minute})
+	ctx := context.Background()
+
+	if _, err := provider.Records(ctx); err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	fetchedAt := testutil.ToFloat64(lastSyncTimestamp)
+	if fetchedAt == 0 {
+		t.Fatal("Records that read Zone.ee did not mark a sync")
+	}
+
+	// Teine kutse vastatakse snapshotist ega tohi sünkroniseerimise aega edasi nihutada
+	lastSyncTimestamp.Set(1)
+	if _, err := provider.Records(ctx); err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	if got := testutil.ToFloat64(lastSyncTimestamp); got != 1 {
+		t.Errorf("last sync timestamp = %g after a cached Records call, want it unchanged", got)
+	}
+}
+
+func TestGetZoneNameFromEndpoint(t *testing.T) {
+	zones := []string{"example.ee", "sub.example.ee", "Other.EE.", "ample.ee"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.ee", "example.ee"},
+		{"www.example.ee", "example.ee"},
+		{"sub.example.ee", "sub.example.ee"},
+		{"www.sub.example.ee", "sub.example.ee"},
+		{"a.b.sub.example.ee", "sub.example.ee"},
+		{"xsub.example.ee", "example.ee"},
+		{"WWW.Example.EE", "example.ee"},
+		{"www.example.ee.", "example.ee"},
+		{"www.other.ee", "other.ee"},
+		{"other.ee.", "other.ee"},
+		{"ample.ee", "ample.ee"},
+		{"www.ample.ee", "ample.ee"},
+		{"example.com", ""},
+		{"notexample.ee", ""},
+		{"ee", ""},
+		{"example.ee.evil.com", ""},
+	}
+	var p ZoneProvider
+	for _, tt := range tests {
+		// NewEndpoint eemaldaks lõpupunkti, seega loome endpointi otse
+		ep := &endpoint.Endpoint{DNSName: tt.name, RecordType: "A", Targets: endpoint.Targets{"1.2.3.4"}}
+		if got := p.getZoneNameFromEndpoint(zones, ep); got != tt.want {
+			t.Errorf("getZoneNameFromEndpoint(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := p.getZoneNameFromEndpoint(nil, endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4")); got != "" {
+		t.Errorf("getZoneNameFromEndpoint without zones = %q, want empty", got)
+	}
+}
+
+func TestRecordsKeepTXTOwnershipFirst(t *testing.T) {
+	// Tipus on kinnitustoken ja external-dns omanikukirje, token on tähestikus eespool
+	const heritage = "heritage=external-dns,external-dns/owner=default,external-dns/resource=service/default/web"
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dns":
+			w.Write([]byte(`[{"name":"example.ee"}]`))
+		case "/dns/example.ee/txt":
+			w.Write([]byte(`[
+				{"id":"1","name":"example.ee","destination":"google-site-verification=abc","modify":true,"delete":true},
+				{"id":"2","name":"example.ee","destination":"` + heritage + `","modify":true,"delete":true},
+				{"id":"3","name":"example.ee","destination":"MS=ms123","modify":true,"delete":true}
+			]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	})
+	provider := newTestProvider(t, api, ZoneProviderConfig{})
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	owners := 0
+	var txt []string
+	for _, ep := range endpoints {
+		if ep.RecordType != endpoint.RecordTypeTXT {
+			continue
+		}
+		if len(ep.Targets) != 1 {
+			t.Errorf("TXT endpoint %s has targets %v, want one endpoint per TXT record", ep.DNSName, ep.Targets)
+		}
+		txt = append(txt, ep.Targets...)
+		// Nii loeb omaniku silte external-dns TXT registry
+		if labels, err := endpoint.NewLabelsFromString(ep.Targets[0], nil); err == nil && labels[endpoint.OwnerLabelKey] == "default" {
+			owners++
+		}
+	}
+	if len(txt) != 3 {
+		t.Errorf("TXT targets = %v, want all three records", txt)
+	}
+	if owners != 1 {
+		t.Errorf("ownership record found in Targets[0] of %d endpoints, want 1; TXT targets: %v", owners, txt)
+	}
+}
+
+func TestSubdomainFilterScopesRecordsAndChanges(t *testing.T) {
+	api := newFakeZoneAPI(map[string][][2]string{
+		"a":   {{"example.ee", "1.1.1.1"}, {"www.example.ee", "2.2.2.2"}, {"app.team-a.example.ee", "3.3.3.3"}, {"team-a.example.ee", "4.4.4.4"}},
+		"txt": {{"app.team-a.example.ee", "heritage=external-dns"}, {"team-b.example.ee", "heritage=external-dns"}},
+	})
+
+	tests := []struct {
+		name    string
+		filter  endpoint.DomainFilter
+		want    []string
+		outside string
+	}{
+		{"subdomain filter", endpoint.NewDomainFilter([]string{"team-a.example.ee"}),
+			[]string{"app.team-a.example.ee A", "app.team-a.example.ee TXT", "team-a.example.ee A"}, "www.example.ee"},
+		{"dot-prefixed filter", endpoint.NewDomainFilter([]string{".team-a.example.ee"}),
+			[]string{"app.team-a.example.ee A", "app.team-a.example.ee TXT"}, "team-a.example.ee"},
+		{"exclusion inside the zone", endpoint.NewDomainFilterWithExclusions([]string{"example.ee"}, []string{"team-a.example.ee"}),
+			[]string{"example.ee A", "team-b.example.ee TXT", "www.example.ee A"}, "app.team-a.example.ee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, api, ZoneProviderConfig{DomainFilter: tt.filter})
+			ctx := context.Background()
+
+			endpoints, err := provider.Records(ctx)
+			if err != nil {
+				t.Fatalf("Records: %v", err)
+			}
+			var got []string
+			for _, ep := range endpoints {
+				got = append(got, ep.DNSName+" "+ep.RecordType)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Records = %q, want %q", got, tt.want)
+			}
+
+			// Filtrist välja jääv nimi samas tsoonis lükatakse tagasi ilma Zone.ee poole pöördumata
+			changes := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint(tt.outside, "A", "9.9.9.9")}}
+			err = provider.ApplyChanges(ctx, changes)
+			var filterErr *DomainFilterError
+			if !errors.As(err, &filterErr) || filterErr.DNSName != tt.outside {
+				t.Errorf("ApplyChanges(%s) = %v, want *DomainFilterError", tt.outside, err)
+			}
+			if writes := api.Writes(); len(writes) != 0 {
+				t.Errorf("writes = %q, want none", writes)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Fail: ratelimit.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig kirjeldab kliendipoolset päringute tempot
+type RateLimitConfig struct {
+	RequestsPerSecond float64       // Fikseeritud ülempiir, 0 = ülempiiri pole (aeglustatakse ainult päiste järgi)
+	Burst             int           // Mitu päringut võib korraga teha, kui tempo on piiratud
+	Window            time.Duration // Aken, mille jooksul X-Ratelimit-Remaining ära jagatakse
+}
+
+// DefaultRateLimitConfig ei sea fikseeritud ülempiiri: iga tsoon on 8 listingu päringut ja fikseeritud
+// tempo muudaks mitme tsooniga konto lugemise päringu ajalimiidist pikemaks. Tempot piiratakse alles siis,
+// kui Zone.ee X-Ratelimit-* päised näitavad, et kvoot hakkab otsa saama.
+var DefaultRateLimitConfig = RateLimitConfig{
+	RequestsPerSecond: 0,
+	Burst:             5,
+	Window:            time.Minute,
+}
+
+// lowQuotaFraction: kui akna kvoodist on järel alla selle osa, jagame ülejäänud päringud akna peale laiali
+const lowQuotaFraction = 0.2
+
+// RateLimiterState on piiraja hetkeseis logide ja mõõdikute jaoks
+type RateLimiterState struct {
+	Limit          float64 // Hetkel kehtiv tempo (päringut sekundis), 0 = piiramata
+	QuotaLimit     int     // Viimane X-Ratelimit-Limit väärtus, -1 kui pole teada
+	QuotaRemaining int     // Viimane X-Ratelimit-Remaining väärtus, -1 kui pole teada
+}
+
+// apiRateLimiter on kõigi tsoonide vahel jagatud token-bucket piiraja, mis kohandab tempot
+// Zone.ee X-Ratelimit-* päiste järgi. Kui sama kontot kasutab mitu webhooki, näeme nende
+// tarbimist Remaining väärtusest ja aeglustame, et kvoot ei saaks sünkroniseerimise keskel otsa.
+// Kuni päised aeglustamist ei nõua, kehtib seadistatud ülempiir (vaikimisi piiramata).
+type apiRateLimiter struct {
+	mu         sync.Mutex
+	limiter    *rate.Limiter
+	configured rate.Limit
+	window     time.Duration
+	state      RateLimiterState
+}
+
+// newAPIRateLimiter loob piiraja, RequestsPerSecond <= 0 korral on tempo piiramata, kuni päised teisiti ütlevad
+func newAPIRateLimiter(cfg RateLimitConfig) *apiRateLimiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultRateLimitConfig.Window
+	}
+	limit := rate.Inf
+	if cfg.RequestsPerSecond > 0 {
+		limit = rate.Limit(cfg.RequestsPerSecond)
+	}
+	return &apiRateLimiter{
+		limiter:    rate.NewLimiter(limit, cfg.Burst),
+		configured: limit,
+		window:     cfg.Window,
+		state:      RateLimiterState{Limit: limitValue(limit), QuotaLimit: -1, QuotaRemaining: -1},
+	}
+}
+
+// limitValue teisendab tempo logide ja oleku jaoks, piiramata tempo on 0
+func limitValue(limit rate.Limit) float64 {
+	if limit == rate.Inf {
+		return 0
+	}
+	return float64(limit)
+}
+
+// Wait ootab, kuni järgmise päringu võib teha. nil piiraja korral ei oota.
+func (l *apiRateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Observe kohandab tempot vastuse päiste järgi. throttled on true, kui server vastas 429.
+func (l *apiRateLimiter) Observe(ctx context.Context, header http.Header, throttled bool) {
+	if l == nil {
+		return
+	}
+	quotaLimit, limitErr := strconv.Atoi(header.Get("X-Ratelimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("X-Ratelimit-Remaining"))
+	if throttled && remainingErr != nil {
+		remaining, remainingErr = 0, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limitErr == nil && quotaLimit > 0 {
+		l.state.QuotaLimit = quotaLimit
+	}
+	if remainingErr == nil && remaining >= 0 {
+		l.state.QuotaRemaining = remaining
+	}
+	if l.state.QuotaLimit <= 0 || l.state.QuotaRemaining < 0 {
+		return // Päiseid pole, jääme seadistatud tempo juurde
+	}
+
+	// Aeglustame ainult siis, kui päised seda nõuavad, muidu kehtib seadistatud ülempiir
+	newLimit := l.configured
+	if float64(l.state.QuotaRemaining) < float64(l.state.QuotaLimit)*lowQuotaFraction {
+		// Kvoot hakkab otsa saama (ka teiste webhookide tõttu): jagame ülejäänu akna peale laiali,
+		// aga vähemalt üks päring akna kohta, et taastuda pärast akna lähtestumist
+		spread := rate.Limit(float64(max(l.state.QuotaRemaining, 1)) / l.window.Seconds())
+		newLimit = min(newLimit, spread)
+	}
+
+	if newLimit != l.limiter.Limit() {
+		logFrom(ctx).Info("Rate limiter adjusted", "from", limitValue(l.limiter.Limit()), "to", limitValue(newLimit),
+			"quota_remaining", l.state.QuotaRemaining, "quota_limit", l.state.QuotaLimit, "window", l.window.String())
+		l.limiter.SetLimit(newLimit)
+	}
+	l.state.Limit = limitValue(newLimit)
+}
+
+// State tagastab piiraja hetkeseisu
+func (l *apiRateLimiter) State() RateLimiterState {
+	if l == nil {
+		return RateLimiterState{QuotaLimit: -1, QuotaRemaining: -1}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
@@ -0,0 +1,49 @@
+// Fail: ratelimit_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func quotaHeader(limit, remaining string) http.Header {
+	h := http.Header{}
+	h.Set("X-Ratelimit-Limit", limit)
+	h.Set("X-Ratelimit-Remaining", remaining)
+	return h
+}
+
+func TestRateLimiterSlowsDownOnlyWhenQuotaRunsLow(t *testing.T) {
+	l := newAPIRateLimiter(DefaultRateLimitConfig)
+	if got := l.State().Limit; got != 0 {
+		t.Fatalf("default limit = %g, want 0 (unlimited)", got)
+	}
+
+	l.Observe(context.Background(), quotaHeader("60", "50"), false)
+	if got := l.State().Limit; got != 0 {
+		t.Errorf("limit with plenty of quota = %g, want 0 (unlimited)", got)
+	}
+
+	l.Observe(context.Background(), quotaHeader("60", "6"), false)
+	if got := l.State().Limit; got != 0.1 {
+		t.Errorf("limit with 6 of 60 remaining = %g, want 0.1 (6 requests spread over 1m)", got)
+	}
+
+	l.Observe(context.Background(), quotaHeader("60", "59"), false)
+	if got := l.State().Limit; got != 0 {
+		t.Errorf("limit after the window reset = %g, want 0 (unlimited)", got)
+	}
+}
+
+func TestRateLimiterKeepsConfiguredCap(t *testing.T) {
+	l := newAPIRateLimiter(RateLimitConfig{RequestsPerSecond: 2, Burst: 1})
+	l.Observe(context.Background(), quotaHeader("1000", "900"), false)
+	if got := l.State().Limit; got != 2 {
+		t.Errorf("limit = %g, want the configured 2", got)
+	}
+	l.Observe(context.Background(), http.Header{}, true)
+	if got := l.State().Limit; got != 1.0/60 {
+		t.Errorf("limit after 429 = %g, want 1 request per window", got)
+	}
+}
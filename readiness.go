@@ -0,0 +1,90 @@
+// Fail: readiness.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultAccessCheckInterval määrab, kui tihti Zone.ee volitusi ja tsoonide ligipääsu taustal kontrollitakse
+const DefaultAccessCheckInterval = 5 * time.Minute
+
+// accessCheckTimeout piirab ühe kontrolli kestust, et rippuv Zone.ee päring ei jätaks tulemust vanaks
+const accessCheckTimeout = time.Minute
+
+// errAccessNotChecked on valmisoleku vastus enne esimese kontrolli lõppu
+var errAccessNotChecked = errors.New("zone.ee access has not been checked yet")
+
+// AccessCheck kontrollib taustal perioodiliselt, kas Zone.ee volitused kehtivad ja hallatavad tsoonid on loetavad.
+// Viimane tulemus hoitakse meeles, nii et /readyz proovid ise Zone.ee poole päringuid ei tee.
+type AccessCheck struct {
+	check    func(ctx context.Context) error
+	interval time.Duration
+
+	mu        sync.RWMutex
+	err       error
+	checkedAt time.Time
+}
+
+// NewAccessCheck loob kontrolli, interval <= 0 korral kasutatakse vaikeväärtust.
+// Kuni esimese eduka kontrollini on tulemus mitte-valmis.
+func NewAccessCheck(check func(ctx context.Context) error, interval time.Duration) *AccessCheck {
+	if interval <= 0 {
+		interval = DefaultAccessCheckInterval
+	}
+	return &AccessCheck{check: check, interval: interval, err: errAccessNotChecked}
+}
+
+// Run teeb kontrolli kohe ja seejärel iga intervalli järel, kuni ctx tühistatakse
+func (a *AccessCheck) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		a.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce teeb ühe kontrolli ja salvestab tulemuse. Oleku muutus logitakse veana, korduvad vead hoiatusena.
+func (a *AccessCheck) runOnce(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, accessCheckTimeout)
+	defer cancel()
+	err := a.check(checkCtx)
+	if ctx.Err() != nil {
+		// Seiskamise ajal katkenud kontroll ei muuda tulemust
+		return
+	}
+
+	a.mu.Lock()
+	previous := a.err
+	a.err = err
+	a.checkedAt = time.Now()
+	a.mu.Unlock()
+
+	switch {
+	case err == nil && previous != nil:
+		slog.Info("Zone.ee access check passed, webhook is ready")
+		accessCheckGauge.Set(1)
+	case err != nil && previous == nil:
+		slog.Error("Zone.ee access check failed, webhook is not ready", logKeyError, err)
+		accessCheckGauge.Set(0)
+	case err != nil && previous == errAccessNotChecked:
+		slog.Error("Zone.ee access check failed, webhook stays not ready", logKeyError, err)
+	case err != nil:
+		slog.Warn("Zone.ee access check still failing", logKeyError, err)
+	}
+}
+
+// Result tagastab viimase kontrolli aja ja vea (nil = valmis)
+func (a *AccessCheck) Result() (time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.checkedAt, a.err
+}
@@ -0,0 +1,192 @@
+// Fail: record_index.go
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRecordsCacheMaxAge määrab, kui kaua tsooni kirjete snapshot kehtib, enne kui Records selle uuesti loeb
+const DefaultRecordsCacheMaxAge = 5 * time.Minute
+
+// recordKey identifitseerib Zone.ee kirje external-dns vaatest: nimi, tüüp ja sihtmärk
+type recordKey struct {
+	Name       string
+	RecordType string
+	Target     string
+}
+
+// newRecordKey normaliseerib nime ja sihtmärgi, et external-dns ja Zone.ee vormingud kattuksid
+func newRecordKey(name, recordType, target string) recordKey {
+	recordType = strings.ToUpper(recordType)
+	switch recordType {
+	case "TXT":
+		// TXT sisu on läbipaistev
+	case "AAAA":
+		if canonical, err := canonicalIPv6(target); err == nil {
+			target = canonical
+		}
+	case "CAA":
+		if flag, tag, value, err := parseCAATarget(target); err == nil {
+			target = formatCAATarget(flag, tag, value)
+		}
+	default:
+		// Teistel tüüpidel lõpupunkt ei muuda tähendust
+		target = strings.TrimSuffix(target, ".")
+	}
+	return recordKey{
+		Name:       strings.ToLower(strings.TrimSuffix(name, ".")),
+		RecordType: recordType,
+		Target:     target,
+	}
+}
+
+// zoneSnapshot on ühe tsooni kirjed listingu järjekorras koos lugemise ajaga
+type zoneSnapshot struct {
+	records   []ZoneRecord
+	byKey     map[recordKey][]ZoneRecord
+	fetchedAt time.Time
+}
+
+// rebuild ehitab võtmete vastavuse kirjete põhjal uuesti
+func (z *zoneSnapshot) rebuild() {
+	z.byKey = make(map[recordKey][]ZoneRecord, len(z.records))
+	for _, r := range z.records {
+		key := newRecordKey(r.Name, r.RecordType, r.Target)
+		z.byKey[key] = append(z.byKey[key], r)
+	}
+}
+
+// recordIndex hoiab tsoonide kaupa vastavust (nimi, tüüp, sihtmärk) -> Zone.ee kirjed (ID ja õigused)
+// ning on ühtlasi Records vastuste snapshot cache. Indeks ehitatakse iga tsooni värskest listingust
+// uuesti, SetIdentifieri me selleks ei kasuta. Oma kirjutuste järel uuendatakse snapshotti Zone.ee
+// vastuse põhjal kohapeal, vigade korral visatakse tsoon cache'ist välja.
+// Ühe võtme all võib olla mitu kirjet, kui Zone.ee-s on duplikaadid; neid käsitleb resolver veana.
+// Iga tsooni põlvkonda suurendab iga oma kirjutus (upsert, remove, evict), nii saab ära tunda listingu,
+// mis algas enne kirjutust ja ei tohi selle tulemust üle kirjutada.
+type recordIndex struct {
+	mu          sync.RWMutex
+	zones       map[string]*zoneSnapshot
+	generations map[string]uint64
+}
+
+// newRecordIndex loob tühja indeksi
+func newRecordIndex() *recordIndex {
+	return &recordIndex{zones: make(map[string]*zoneSnapshot), generations: make(map[string]uint64)}
+}
+
+// generation tagastab tsooni praeguse põlvkonna, see loetakse enne listingu algust
+func (idx *recordIndex) generation(zoneName string) uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.generations[zoneName]
+}
+
+// replaceZone asendab tsooni kõik kirjed antud listinguga. Kui tsooni põlvkond on listingu ajal
+// muutunud (meie oma kirjutus jõudis vahele), on listing vananenud ja see jäetakse kõrvale.
+// Tagastab, kas snapshot asendati.
+func (idx *recordIndex) replaceZone(zoneName string, records []ZoneRecord, generation uint64) bool {
+	snapshot := &zoneSnapshot{records: records, fetchedAt: time.Now()}
+	snapshot.rebuild()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.generations[zoneName] != generation {
+		return false
+	}
+	idx.zones[zoneName] = snapshot
+	return true
+}
+
+// lookup tagastab kõik võtmele vastavad kirjed
+func (idx *recordIndex) lookup(zoneName string, key recordKey) []ZoneRecord {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	snapshot := idx.zones[zoneName]
+	if snapshot == nil {
+		return nil
+	}
+	return snapshot.byKey[key]
+}
+
+// has kontrollib, kas tsooni kirjed on indeksis olemas
+func (idx *recordIndex) has(zoneName string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.zones[zoneName] != nil
+}
+
+// snapshot tagastab tsooni kirjete koopia, kui need on loetud viimase maxAge jooksul
+func (idx *recordIndex) snapshot(zoneName string, maxAge time.Duration) ([]ZoneRecord, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	snapshot := idx.zones[zoneName]
+	if snapshot == nil || time.Since(snapshot.fetchedAt) >= maxAge {
+		return nil, false
+	}
+	return append([]ZoneRecord(nil), snapshot.records...), true
+}
+
+// upsert lisab või asendab (ID järgi) Zone.ee vastusest saadud kirjed. Snapshoti vanust ei muudeta,
+// sest ülejäänud kirjed on endiselt sama vanad. Kui vastuses kirjeid polnud, ei tea me tulemust
+// ja tsoon visatakse välja.
+func (idx *recordIndex) upsert(zoneName string, records []ZoneRecord) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.generations[zoneName]++
+	snapshot := idx.zones[zoneName]
+	if snapshot == nil {
+		return
+	}
+	if len(records) == 0 {
+		delete(idx.zones, zoneName)
+		return
+	}
+	updated := append([]ZoneRecord(nil), snapshot.records...)
+	for _, r := range records {
+		i := indexOfRecord(updated, r.RecordType, r.ID)
+		if i < 0 {
+			updated = append(updated, r)
+			continue
+		}
+		updated[i] = r
+	}
+	snapshot.records = updated
+	snapshot.rebuild()
+}
+
+// remove eemaldab kirje tüübi ja ID järgi
+func (idx *recordIndex) remove(zoneName, recordType, id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.generations[zoneName]++
+	snapshot := idx.zones[zoneName]
+	if snapshot == nil {
+		return
+	}
+	i := indexOfRecord(snapshot.records, recordType, id)
+	if i < 0 {
+		return
+	}
+	updated := append([]ZoneRecord(nil), snapshot.records[:i]...)
+	snapshot.records = append(updated, snapshot.records[i+1:]...)
+	snapshot.rebuild()
+}
+
+// evict viskab tsooni snapshoti välja, järgmine Records loeb selle Zone.ee-st uuesti
+func (idx *recordIndex) evict(zoneName string) {
+	idx.mu.Lock()
+	idx.generations[zoneName]++
+	delete(idx.zones, zoneName)
+	idx.mu.Unlock()
+}
+
+// indexOfRecord leiab kirje positsiooni tüübi ja ID järgi (ID on unikaalne ainult tüübi piires)
+func indexOfRecord(records []ZoneRecord, recordType, id string) int {
+	for i, r := range records {
+		if r.ID == id && strings.EqualFold(r.RecordType, recordType) {
+			return i
+		}
+	}
+	return -1
+}
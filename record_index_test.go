@@ -0,0 +1,46 @@
+// Fail: record_index_test.go
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordIndexDropsListingStartedBeforeWrite(t *testing.T) {
+	stale := []ZoneRecord{{ID: "1", Name: "www.example.ee", RecordType: "A", Target: "1.2.3.4"}}
+	created := []ZoneRecord{{ID: "2", Name: "api.example.ee", RecordType: "A", Target: "5.6.7.8"}}
+
+	tests := []struct {
+		name  string
+		write func(idx *recordIndex)
+	}{
+		{"upsert", func(idx *recordIndex) { idx.upsert("example.ee", created) }},
+		{"remove", func(idx *recordIndex) { idx.remove("example.ee", "A", "1") }},
+		{"evict", func(idx *recordIndex) { idx.evict("example.ee") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newRecordIndex()
+			idx.replaceZone("example.ee", nil, idx.generation("example.ee"))
+
+			// Listing algab, kirjutus jõuab enne listingu lõppu vahele
+			generation := idx.generation("example.ee")
+			tt.write(idx)
+			after, afterOK := idx.snapshot("example.ee", time.Minute)
+			if idx.replaceZone("example.ee", stale, generation) {
+				t.Fatal("replaceZone accepted a listing that started before the write")
+			}
+			got, ok := idx.snapshot("example.ee", time.Minute)
+			if ok != afterOK || len(got) != len(after) {
+				t.Errorf("snapshot = %+v (ok %t), want the post-write state %+v (ok %t)", got, ok, after, afterOK)
+			}
+
+			if !idx.replaceZone("example.ee", stale, idx.generation("example.ee")) {
+				t.Fatal("replaceZone rejected a listing that started after the write")
+			}
+			if got, _ := idx.snapshot("example.ee", time.Minute); len(got) != 1 || got[0].ID != "1" {
+				t.Errorf("snapshot = %+v, want the fresh listing", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Fail: records_flight.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// recordsFlight jagab samaaegsete Records kutsete vahel ühte Zone.ee lugemist. Erinevalt singleflight'ist
+// loendatakse ootajaid: lugemine tühistatakse, kui viimane ootaja lahkub (nt external-dns katkestas
+// ühenduse), ja lugemise ajalimiit on ootajate hiliseim ajalimiit, nii et hiljem liitunud kutsuja
+// ei päri esimese kutsuja lühemat ajalimiiti.
+type recordsFlight struct {
+	mu    sync.Mutex
+	calls map[string]*recordsCall
+}
+
+// recordsCall on üks käimasolev lugemine
+type recordsCall struct {
+	ctx  context.Context // Lugemise kontekst, tühistatud lugemisega ei liituta
+	done chan struct{}
+	val  []*endpoint.Endpoint
+	err  error
+
+	// Järgmised väljad on kaitstud recordsFlight.mu-ga
+	waiters   int
+	cancel    context.CancelCauseFunc
+	timer     *time.Timer // Tühistab lugemise hiliseima ajalimiidi saabudes
+	deadline  time.Time
+	unbounded bool // Vähemalt ühel ootajal pole ajalimiiti
+}
+
+// Do käivitab fetch'i või liitub sama võtmega käimasoleva lugemisega ja ootab tulemust.
+// shared näitab, kas tulemus tuli teise kutsuja alustatud lugemisest. Tulemust jagavad
+// mitu kutsujat, seega see on ainult lugemiseks.
+func (f *recordsFlight) Do(ctx context.Context, key string, fetch func(ctx context.Context) ([]*endpoint.Endpoint, error)) (val []*endpoint.Endpoint, shared bool, err error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*recordsCall)
+	}
+	call, shared := f.calls[key]
+	if shared && !call.extendDeadline(ctx) {
+		// Käimasoleva lugemise ajalimiit on juba möödunud, selle asemel alustame uue
+		shared = false
+	}
+	if !shared {
+		// Lugemine ei sõltu alustaja tühistamisest, küll aga kasutab selle konteksti väärtusi (logger, trace)
+		fetchCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		call = &recordsCall{ctx: fetchCtx, done: make(chan struct{}), cancel: cancel}
+		f.calls[key] = call
+		call.extendDeadline(ctx)
+		go f.run(fetchCtx, key, call, fetch)
+	}
+	call.waiters++
+	f.mu.Unlock()
+
+	select {
+	case <-call.done:
+		f.leave(key, call, nil)
+		return call.val, shared, call.err
+	case <-ctx.Done():
+		f.leave(key, call, ctx.Err())
+		return nil, shared, ctx.Err()
+	}
+}
+
+// run teeb lugemise ja teavitab ootajaid
+func (f *recordsFlight) run(ctx context.Context, key string, call *recordsCall, fetch func(ctx context.Context) ([]*endpoint.Endpoint, error)) {
+	val, err := fetch(ctx)
+	if cause := context.Cause(ctx); err != nil && cause != nil && !errors.Is(err, cause) {
+		// Ajalimiidi ületamine peab jääma errors.Is abil äratuntavaks
+		err = fmt.Errorf("%w: %w", err, cause)
+	}
+
+	f.mu.Lock()
+	if f.calls[key] == call {
+		delete(f.calls, key)
+	}
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	f.mu.Unlock()
+	call.cancel(nil)
+
+	call.val, call.err = val, err
+	close(call.done)
+}
+
+// leave eemaldab ootaja. Kui viimane ootaja lahkub enne tulemust, lugemine tühistatakse ja
+// järgmine kutse alustab uut lugemist.
+func (f *recordsFlight) leave(key string, call *recordsCall, cause error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	call.waiters--
+	if call.waiters > 0 || cause == nil {
+		return
+	}
+	if f.calls[key] == call {
+		delete(f.calls, key)
+	}
+	call.cancel(cause)
+}
+
+// extendDeadline pikendab lugemise ajalimiidi uue ootaja ajalimiidini, kui see on hilisem.
+// Ootaja ilma ajalimiidita eemaldab ajalimiidi täielikult. Tagastab false, kui lugemine on juba
+// tühistatud või selle taimer käivitunud, siis ei saa ajalimiiti pikendada ja tuleb alustada uut
+// lugemist. Kutsutakse recordsFlight.mu all.
+func (c *recordsCall) extendDeadline(ctx context.Context) bool {
+	if c.ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	switch {
+	case c.unbounded:
+	case !ok:
+		if c.timer != nil && !c.timer.Stop() {
+			return false
+		}
+		c.unbounded = true
+	case c.timer == nil:
+		c.deadline = deadline
+		c.timer = time.AfterFunc(time.Until(deadline), func() { c.cancel(context.DeadlineExceeded) })
+	case deadline.After(c.deadline):
+		if !c.timer.Stop() {
+			return false
+		}
+		c.deadline = deadline
+		c.timer.Reset(time.Until(deadline))
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+// Fail: records_flight_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// blockingFetch tagastab fetch'i, mis ootab release kanalit või konteksti tühistamist
+func blockingFetch(started chan<- context.Context, release <-chan struct{}) func(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	return func(ctx context.Context) ([]*endpoint.Endpoint, error) {
+		started <- ctx
+		select {
+		case <-release:
+			return []*endpoint.Endpoint{endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4")}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestRecordsFlightCancelsWhenLastWaiterLeaves(t *testing.T) {
+	var flight recordsFlight
+	started := make(chan context.Context, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := flight.Do(ctx, "example.ee", blockingFetch(started, nil))
+		errc <- err
+	}()
+
+	fetchCtx := <-started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-fetchCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("fetch was not cancelled after its only waiter left")
+	}
+}
+
+func TestRecordsFlightKeepsFetchForRemainingWaiters(t *testing.T) {
+	var flight recordsFlight
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	fetch := blockingFetch(started, release)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := flight.Do(firstCtx, "example.ee", fetch)
+		firstErr <- err
+	}()
+	fetchCtx := <-started
+
+	type result struct {
+		val    []*endpoint.Endpoint
+		shared bool
+		err    error
+	}
+	second := make(chan result, 1)
+	go func() {
+		val, shared, err := flight.Do(context.Background(), "example.ee", fetch)
+		second <- result{val, shared, err}
+	}()
+	waitForWaiters(t, &flight, "example.ee", 2)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first err = %v, want context.Canceled", err)
+	}
+	if fetchCtx.Err() != nil {
+		t.Fatal("fetch was cancelled although a waiter remains")
+	}
+	close(release)
+	res := <-second
+	if res.err != nil || !res.shared || len(res.val) != 1 {
+		t.Errorf("second caller got %+v, want the shared result", res)
+	}
+}
+
+func TestRecordsFlightUsesLatestDeadline(t *testing.T) {
+	var flight recordsFlight
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	fetch := blockingFetch(started, release)
+
+	shortCtx, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	shortErr := make(chan error, 1)
+	go func() {
+		_, _, err := flight.Do(shortCtx, "example.ee", fetch)
+		shortErr <- err
+	}()
+	fetchCtx := <-started
+
+	longCtx, cancelLong := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelLong()
+	longErr := make(chan error, 1)
+	go func() {
+		_, _, err := flight.Do(longCtx, "example.ee", fetch)
+		longErr <- err
+	}()
+	waitForWaiters(t, &flight, "example.ee", 2)
+
+	if err := <-shortErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("short caller err = %v, want context.DeadlineExceeded", err)
+	}
+	// Esimese kutsuja ajalimiit on möödas, jagatud lugemine peab siiski jätkuma
+	time.Sleep(50 * time.Millisecond)
+	if fetchCtx.Err() != nil {
+		t.Fatalf("fetch inherited the first caller's deadline: %v", context.Cause(fetchCtx))
+	}
+	close(release)
+	if err := <-longErr; err != nil {
+		t.Errorf("long caller err = %v, want the shared result", err)
+	}
+}
+
+func TestRecordsFlightDoesNotJoinExpiredFetch(t *testing.T) {
+	var flight recordsFlight
+	// Käimasolev lugemine, mille taimer on juba käivitunud, aga viimane ootaja pole veel lahkunud
+	expiredCtx, cancel := context.WithCancelCause(context.Background())
+	cancel(context.DeadlineExceeded)
+	expired := &recordsCall{ctx: expiredCtx, done: make(chan struct{}), cancel: cancel, waiters: 1,
+		deadline: time.Now().Add(-time.Millisecond)}
+	flight.calls = map[string]*recordsCall{"example.ee": expired}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelCtx()
+	fetches := 0
+	val, shared, err := flight.Do(ctx, "example.ee", func(fetchCtx context.Context) ([]*endpoint.Endpoint, error) {
+		fetches++
+		if err := fetchCtx.Err(); err != nil {
+			return nil, err
+		}
+		return []*endpoint.Endpoint{endpoint.NewEndpoint("www.example.ee", "A", "1.2.3.4")}, nil
+	})
+	if err != nil || shared || len(val) != 1 {
+		t.Fatalf("Do = %v, shared %t, %v; want a fresh fetch instead of joining the expired one", val, shared, err)
+	}
+	if fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+	if expired.waiters != 1 {
+		t.Errorf("expired fetch waiters = %d, want 1 (the new caller must not join it)", expired.waiters)
+	}
+}
+
+// waitForWaiters ootab, kuni lugemisel on n ootajat
+func waitForWaiters(t *testing.T, flight *recordsFlight, key string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		flight.mu.Lock()
+		call := flight.calls[key]
+		waiters := 0
+		if call != nil {
+			waiters = call.waiters
+		}
+		flight.mu.Unlock()
+		if waiters == n {
+			return
+		}
+	}
+	t.Fatalf("fetch %s did not reach %d waiters", key, n)
+}
@@ -0,0 +1,171 @@
+// Fail: tracing.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName on selle rakenduse spanide instrumentatsiooni nimi
+const tracerName = "external-dns-zoneee-webhook"
+
+// DefaultTraceSampleRatio on vaikimisi osa jälgitavatest päringutest, kui external-dns ise otsust ei edasta
+const DefaultTraceSampleRatio = 1.0
+
+// Spanide atribuudid
+const (
+	traceKeyZone       = attribute.Key("zoneee.zone")
+	traceKeyRecordType = attribute.Key("zoneee.record_type")
+	traceKeyAttempts   = attribute.Key("zoneee.attempts")
+	traceKeyMethod     = attribute.Key("http.request.method")
+	traceKeyRoute      = attribute.Key("http.route")
+	traceKeyStatusCode = attribute.Key("http.response.status_code")
+)
+
+// TracingConfig kirjeldab OTLP jälitamise seadistust
+type TracingConfig struct {
+	// Endpoint on OTLP/HTTP kollektori aadress (nt http://otel-collector:4318), tühi = jälitamine välja lülitatud
+	Endpoint string
+	// SampleRatio on osa (0..1) juurspanidest, mis salvestatakse; external-dns sampling otsust austatakse alati
+	SampleRatio float64
+	// Redactor eemaldab spanide veateadetest saladused ja tundlikud TXT väärtused, nagu logidest
+	Redactor *redactor
+}
+
+// spanRedactor on setupTracing poolt seatud redaktor, mida endAPISpan veateadetele rakendab
+var spanRedactor *redactor
+
+// setupTracing seadistab globaalse TracerProvideri. Tühja Endpointi korral jääb kasutusele OpenTelemetry
+// no-op provider ja spanid ei maksa midagi. Tagastatud shutdown saadab puhvris olevad spanid ära.
+func setupTracing(ctx context.Context, cfg TracingConfig) (shutdown func(context.Context) error, err error) {
+	// Trace kontekst võetakse sissetulevatest päistest ka siis, kui eksporter puudub
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	spanRedactor = cfg.Redactor
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %g, expected a value between 0 and 1", cfg.SampleRatio)
+	}
+
+	endpointURL, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected a URL such as http://otel-collector:4318", cfg.Endpoint)
+	}
+	if endpointURL.Path == "" || endpointURL.Path == "/" {
+		endpointURL.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	// OTEL_SERVICE_NAME ja OTEL_RESOURCE_ATTRIBUTES kirjutavad vaikimisi teenuse nime üle
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", tracerName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracer tagastab rakenduse tracer'i globaalsest providerist
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// statusRecorder jätab meelde handleri kirjutatud HTTP staatuse
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withTracing loob iga webhook päringu jaoks serveri spani. Kui external-dns saadab traceparent päise,
+// saab span selle lapseks, nii on kogu muudatuste rakendamise ahel ühes jäljes näha.
+func withTracing(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(traceKeyMethod.String(r.Method), traceKeyRoute.String(route)),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(traceKeyStatusCode.Int(recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// startAPISpan loob Zone.ee API kutse jaoks kliendi spani, tsoon ja kirjetüüp tuletatakse teekonnast
+func startAPISpan(ctx context.Context, method, path string) (context.Context, trace.Span) {
+	recordType := metricRecordType(path)
+	attrs := []attribute.KeyValue{traceKeyMethod.String(method), traceKeyRecordType.String(recordType)}
+	if zone := apiPathZone(path); zone != "" {
+		attrs = append(attrs, traceKeyZone.String(zone))
+	}
+	return tracer().Start(ctx, "zoneee "+method+" "+recordType,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endAPISpan salvestab API kutse tulemuse (viimase katse staatus ja katsete arv) ja lõpetab spani.
+// Veateade võib sisaldada Zone.ee vastuse keha (nt TXT väärtusi), seega läbib see sama redaktori kui logid.
+// span.RecordError salvestaks toore teate, seepärast lisatakse exception sündmus ise.
+func endAPISpan(span trace.Span, resp *apiResponse, attempts int, err error) {
+	span.SetAttributes(traceKeyAttempts.Int(attempts))
+	if resp != nil {
+		span.SetAttributes(traceKeyStatusCode.Int(resp.status))
+	}
+	if err != nil {
+		msg := spanRedactor.redact(err.Error())
+		span.AddEvent(semconv.ExceptionEventName, trace.WithAttributes(
+			semconv.ExceptionType(fmt.Sprintf("%T", err)),
+			semconv.ExceptionMessage(msg),
+		))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// apiPathZone tuletab API teekonnast tsooni nime: /dns/{tsoon}/... -> tsoon
+func apiPathZone(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "dns" {
+		return parts[1]
+	}
+	return ""
+}
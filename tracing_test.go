@@ -0,0 +1,50 @@
+// Fail: tracing_test.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndAPISpanRedactsErrors(t *testing.T) {
+	r, err := newRedactor([]string{"s3cr3t"}, []string{`v=DKIM1;[^"]*`})
+	if err != nil {
+		t.Fatalf("newRedactor: %v", err)
+	}
+	previous := spanRedactor
+	spanRedactor = r
+	t.Cleanup(func() { spanRedactor = previous })
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, span := provider.Tracer(tracerName).Start(context.Background(), "zoneee POST txt")
+	apiErr := &ZoneAPIError{Method: "POST", Path: "/dns/example.ee/txt", Status: "422 Unprocessable Entity",
+		Message: `invalid destination "v=DKIM1; k=rsa; p=MIGf" for key s3cr3t`}
+	endAPISpan(span, &apiResponse{status: 422}, 1, fmt.Errorf("failed to create record: %w", apiErr))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("ended spans = %d, want 1", len(spans))
+	}
+	var exported []string
+	exported = append(exported, spans[0].Status().Description)
+	for _, event := range spans[0].Events() {
+		for _, attr := range event.Attributes {
+			exported = append(exported, attr.Value.Emit())
+		}
+	}
+	all := strings.Join(exported, "\n")
+	for _, leaked := range []string{"s3cr3t", "MIGf"} {
+		if strings.Contains(all, leaked) {
+			t.Errorf("span exports %q:\n%s", leaked, all)
+		}
+	}
+	if !strings.Contains(all, redactedValue) || !strings.Contains(all, "*fmt.wrapError") {
+		t.Errorf("span does not record the redacted error and its type:\n%s", all)
+	}
+}
@@ -41,12 +41,47 @@ type SRVRecord struct {
 	ZoneName   string `json:"-"`
 }
 
+// CAARecord Zone.ee API jaoks
+type CAARecord struct {
+	ID           string `json:"id,omitempty"`
+	ResourceURL  string `json:"resource_url,omitempty"`
+	Name         string `json:"name"`
+	Destination  string `json:"destination"` // CAA väärtus, nt "letsencrypt.org"
+	Flag         int    `json:"flag"`
+	Tag          string `json:"tag"` // issue, issuewild, iodef
+	CanDelete    bool   `json:"delete,omitempty"`
+	CanModify    bool   `json:"modify,omitempty"`
+	RecordType string `json:"-"`
+	ZoneName   string `json:"-"`
+}
+
+// DNSZone on Zone.ee konto DNS tsoon (GET /dns vastuse element)
+type DNSZone struct {
+	Name        string `json:"name"`
+	ResourceURL string `json:"resource_url,omitempty"`
+}
+
+// ZoneRecord on normaliseeritud Zone.ee kirje, mille Target on external-dns formaadis
+// (nt MX puhul "priority destination"). Provider ehitab nende põhjal ID indeksi.
+type ZoneRecord struct {
+	ID         string
+	Zone       string
+	Name       string // FQDN
+	RecordType string // Suurtähtedega, nt "A"
+	Target     string
+	CanModify  bool
+	CanDelete  bool
+}
+
 // Vastuste tüübid (massiivid)
 type ZoneARecords []Record
+type ZoneAAAARecords []Record
 type ZoneCNAMERecords []Record
 type ZoneTXTRecords []Record
+type ZoneNSRecords []Record
 type ZoneMXRecords []MXRecord
 type ZoneSRVRecords []SRVRecord
+type ZoneCAARecords []CAARecord
 
 // Päringute kehad (Payloads)
 type CreateRecordPayload struct {
@@ -81,3 +116,15 @@ type UpdateSRVPayload struct {
 	Weight      int    `json:"weight"`
 	Port        int    `json:"port"`
 }
+type CreateCAAPayload struct {
+	Name        string `json:"name"`
+	Destination string `json:"destination"`
+	Flag        int    `json:"flag"`
+	Tag         string `json:"tag"`
+}
+type UpdateCAAPayload struct {
+	Name        string `json:"name"`
+	Destination string `json:"destination"`
+	Flag        int    `json:"flag"`
+	Tag         string `json:"tag"`
+}
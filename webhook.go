@@ -0,0 +1,279 @@
+// Fail: webhook.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// external-dns webhook protokolli meediatüüp ja päised.
+// Väärtused peavad vastama sigs.k8s.io/external-dns/provider/webhook/api konstantidele,
+// aga me ei impordi seda paketti, et vältida kogu provider paketi sõltuvusi.
+const (
+	mediaTypeFormat           = "application/external.dns.webhook+json"
+	mediaTypeVersion          = "1"
+	mediaTypeFormatAndVersion = mediaTypeFormat + ";version=" + mediaTypeVersion
+	contentTypeHeader         = "Content-Type"
+	acceptHeader              = "Accept"
+	varyHeader                = "Vary"
+)
+
+// DefaultRequestDeadline on ühe webhook päringu vaikimisi ajalimiit (sh kõik selle Zone.ee päringud)
+const DefaultRequestDeadline = time.Minute
+
+// WebhookServer seob external-dns webhook HTTP API ZoneProvideriga
+type WebhookServer struct {
+	provider *ZoneProvider
+	// requestDeadline piirab ühe päringu kestust, 0 = ainult kliendi ühenduse katkemine tühistab päringu
+	requestDeadline time.Duration
+	// auth kontrollib päringute bearer tokenit või HMAC allkirja, nil = autentimine välja lülitatud
+	auth *WebhookAuth
+}
+
+// NewWebhookServer loob uue WebhookServer instantsi
+func NewWebhookServer(provider *ZoneProvider, requestDeadline time.Duration, auth *WebhookAuth) *WebhookServer {
+	return &WebhookServer{provider: provider, requestDeadline: requestDeadline, auth: auth}
+}
+
+// requestContext tagastab päringu konteksti koos ajalimiidiga. Kliendi ühenduse katkemine
+// tühistab konteksti ja sellega ka pooleliolevad Zone.ee päringud.
+func (s *WebhookServer) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.requestDeadline <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), s.requestDeadline)
+}
+
+// RegisterHandlers registreerib webhook protokolli handlerid antud mux'is
+func (s *WebhookServer) RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle("/", withTracing("/", withRequestLogger(withAuth(s.auth, http.HandlerFunc(s.NegotiateHandler)))))
+	mux.Handle("/records", withTracing("/records", withRequestLogger(withAuth(s.auth, http.HandlerFunc(s.RecordsHandler)))))
+	mux.Handle("/adjustendpoints", withTracing("/adjustendpoints", withRequestLogger(withAuth(s.auth, http.HandlerFunc(s.AdjustEndpointsHandler)))))
+}
+
+// requestIDHeader kannab päringu ID-d; kui klient seda ei saada, luuakse uus
+const requestIDHeader = "X-Request-Id"
+
+// withRequestLogger seob iga päringuga request_id (ja jälitamise korral trace_id) väljaga loggeri,
+// mida kasutavad ka provider ja klient.
+// ID tagastatakse vastuse päises, et external-dns poole vea saaks webhooki logidega siduda.
+func withRequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		logger := slog.Default().With(logKeyRequestID, requestID, "method", r.Method, "path", r.URL.Path)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			logger = logger.With(logKeyTraceID, spanContext.TraceID().String())
+		}
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+	})
+}
+
+// setMediaTypeHeaders seab vastusele versioonitud meediatüübi ja Vary päise
+func setMediaTypeHeaders(w http.ResponseWriter) {
+	w.Header().Set(contentTypeHeader, mediaTypeFormatAndVersion)
+	w.Header().Set(varyHeader, contentTypeHeader)
+}
+
+// checkMediaType kontrollib, kas Accept või Content-Type päises olev meediatüüp on toetatud.
+// Tühi päis, wildcard ja tavaline application/json on lubatud käsitsi testimise jaoks,
+// webhook meediatüübi puhul peab versioon olema täpselt mediaTypeVersion.
+func checkMediaType(header string) error {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	var lastErr error
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			lastErr = fmt.Errorf("invalid media type %q: %w", part, err)
+			continue
+		}
+		switch mediaType {
+		case mediaTypeFormat:
+			if version := params["version"]; version != mediaTypeVersion {
+				lastErr = fmt.Errorf("unsupported media type version %q, supported: %s", version, mediaTypeFormatAndVersion)
+				continue
+			}
+			return nil
+		case "*/*", "application/*", "application/json":
+			return nil
+		default:
+			lastErr = fmt.Errorf("unsupported media type %q, supported: %s", mediaType, mediaTypeFormatAndVersion)
+		}
+	}
+	return lastErr
+}
+
+// statusForError valib veale vastava HTTP staatuse:
+// vigased volitused on seadistusviga (500 selge teatega logis), ajutised Zone.ee vead ja osaline
+// listing annavad 503, et external-dns jätaks tsükli vahele ja prooviks hiljem uuesti.
+// Vigase kujuga muudatused (nt UpdateOld ja UpdateNew erineva pikkusega) annavad 400.
+func statusForError(ctx context.Context, err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		logFrom(ctx).Warn("Request deadline exceeded before Zone.ee calls finished")
+		return http.StatusServiceUnavailable
+	}
+	var invalidErr *InvalidChangesError
+	if errors.As(err, &invalidErr) {
+		return http.StatusBadRequest
+	}
+	if isUnauthorized(err) {
+		logFrom(ctx).Error("Zone.ee rejected the API credentials. This is a configuration error, check ZONEEE_API_USER and ZONEEE_API_KEY.")
+		return http.StatusInternalServerError
+	}
+	var apiErr *ZoneAPIError
+	if errors.As(err, &apiErr) && apiErr.Retryable {
+		return http.StatusServiceUnavailable
+	}
+	var partialErr *PartialRecordsError
+	if errors.As(err, &partialErr) {
+		// Osaline vaade: external-dns jätab tsükli vahele ja proovib hiljem uuesti
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// NegotiateHandler (GET /) - kontrollib meediatüüpi ja tagastab domeenifiltri
+func (s *WebhookServer) NegotiateHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logFrom(r.Context())
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		logger.Warn("Method not allowed")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logger.Info("Received negotiation request")
+
+	if err := checkMediaType(r.Header.Get(acceptHeader)); err != nil {
+		logger.Warn("Negotiation failed", logKeyError, err)
+		http.Error(w, "Not Acceptable: "+err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	setMediaTypeHeaders(w)
+	if err := json.NewEncoder(w).Encode(s.provider.GetDomainFilter()); err != nil {
+		logger.Error("Failed to encode domain filter response", logKeyError, err)
+	}
+}
+
+// RecordsHandler käsitleb nii GET (lugemine) kui POST (muudatuste rakendamine) päringuid
+func (s *WebhookServer) RecordsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		// GET /records: Tagastab olemasolevad kirjed
+		logger.Info("Received records request")
+		if err := checkMediaType(r.Header.Get(acceptHeader)); err != nil {
+			logger.Warn("Rejecting request", logKeyError, err)
+			http.Error(w, "Not Acceptable: "+err.Error(), http.StatusNotAcceptable)
+			return
+		}
+
+		ctx, cancel := s.requestContext(r)
+		defer cancel()
+		endpoints, err := s.provider.Records(ctx)
+		if err != nil {
+			logger.Error("Failed to get records", logKeyError, err)
+			http.Error(w, "Failed to retrieve records: "+err.Error(), statusForError(ctx, err))
+			return
+		}
+		setMediaTypeHeaders(w)
+		if err := json.NewEncoder(w).Encode(endpoints); err != nil {
+			logger.Error("Failed to encode records response", logKeyError, err)
+		}
+		logger.Info("Responded with records", "endpoints", len(endpoints))
+
+	case http.MethodPost:
+		// POST /records: Rakendab muudatused (ApplyChanges)
+		logger.Info("Received apply changes request")
+		if err := checkMediaType(r.Header.Get(contentTypeHeader)); err != nil {
+			logger.Warn("Rejecting request", logKeyError, err)
+			http.Error(w, "Unsupported Media Type: "+err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var changes plan.Changes
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			logger.Error("Failed to decode request body", logKeyError, err)
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := s.requestContext(r)
+		defer cancel()
+		if err := s.provider.ApplyChanges(ctx, &changes); err != nil {
+			logger.Error("Failed to apply changes", logKeyError, err)
+			http.Error(w, "Failed to apply changes: "+err.Error(), statusForError(ctx, err))
+			return
+		}
+		logger.Info("Changes applied successfully (or logged in dry-run)")
+		w.Header().Set(varyHeader, contentTypeHeader)
+		w.WriteHeader(http.StatusNoContent) // Edukas ApplyChanges tagastab 204
+
+	default:
+		// Muud meetodid pole /records endpointil lubatud
+		logger.Warn("Method not allowed")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdjustEndpointsHandler (POST /adjustendpoints) - Kohandab endpoint'e (AdjustEndpoints)
+func (s *WebhookServer) AdjustEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logFrom(r.Context())
+	if r.Method != http.MethodPost {
+		logger.Warn("Method not allowed")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logger.Info("Received adjust endpoints request")
+
+	if err := checkMediaType(r.Header.Get(contentTypeHeader)); err != nil {
+		logger.Warn("Rejecting request", logKeyError, err)
+		http.Error(w, "Unsupported Media Type: "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := checkMediaType(r.Header.Get(acceptHeader)); err != nil {
+		logger.Warn("Rejecting request", logKeyError, err)
+		http.Error(w, "Not Acceptable: "+err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	var requestedEndpoints []*endpoint.Endpoint
+	if err := json.NewDecoder(r.Body).Decode(&requestedEndpoints); err != nil {
+		logger.Error("Failed to decode request body", logKeyError, err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adjustedEndpoints, err := s.provider.AdjustEndpoints(r.Context(), requestedEndpoints)
+	if err != nil {
+		logger.Error("Failed to adjust endpoints", logKeyError, err)
+		http.Error(w, "Failed to adjust endpoints: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setMediaTypeHeaders(w)
+	if err := json.NewEncoder(w).Encode(adjustedEndpoints); err != nil {
+		logger.Error("Failed to encode adjusted endpoints response", logKeyError, err)
+	}
+	logger.Info("Responded with adjusted endpoints", "endpoints", len(adjustedEndpoints))
+}
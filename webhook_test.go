@@ -0,0 +1,143 @@
+// Fail: webhook_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// newTestWebhook loob webhooki newTestProvider'i peale, provider suhtleb antud Zone.ee asendusega
+func newTestWebhook(t *testing.T, api http.Handler) http.Handler {
+	t.Helper()
+	provider := newTestProvider(t, api, ZoneProviderConfig{StrictRecords: true})
+	mux := http.NewServeMux()
+	NewWebhookServer(provider, DefaultRequestDeadline, nil).RegisterHandlers(mux)
+	return mux
+}
+
+func TestApplyChangesRejectsMismatchedUpdates(t *testing.T) {
+	var apiCalls int
+	webhook := newTestWebhook(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiCalls++
+		w.Write([]byte(`[{"name":"example.ee"}]`))
+	}))
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"update new without old", `{"UpdateNew":[{"dnsName":"www.example.ee","recordType":"A","targets":["1.2.3.4"]}]}`},
+		{"update old without new", `{"UpdateOld":[{"dnsName":"www.example.ee","recordType":"A","targets":["1.2.3.4"]}]}`},
+		{"null endpoint", `{"Create":[null]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failuresBefore := testutil.ToFloat64(applyTotal.WithLabelValues("failure"))
+			req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(tt.body))
+			req.Header.Set(contentTypeHeader, mediaTypeFormatAndVersion)
+			rec := httptest.NewRecorder()
+			webhook.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400; body: %s", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "invalid changes") {
+				t.Errorf("body = %q, want the validation error", rec.Body.String())
+			}
+			if got := testutil.ToFloat64(applyTotal.WithLabelValues("failure")) - failuresBefore; got != 1 {
+				t.Errorf("apply failures recorded = %g, want 1", got)
+			}
+		})
+	}
+	if apiCalls != 0 {
+		t.Errorf("Zone.ee API was called %d times for invalid changes, want 0", apiCalls)
+	}
+}
+
+func TestCheckMediaType(t *testing.T) {
+	tests := []struct {
+		header  string
+		wantErr bool
+	}{
+		{"", false},
+		{mediaTypeFormatAndVersion, false},
+		{"application/external.dns.webhook+json; version=1", false},
+		{"application/external.dns.webhook+json", true},
+		{"application/external.dns.webhook+json;version=2", true},
+		{"application/external.dns.webhook+json;version=2, application/external.dns.webhook+json;version=1", false},
+		{"application/json", false},
+		{"*/*", false},
+		{"text/plain", true},
+		{"application/external.dns.webhook+json;version", true},
+	}
+	for _, tt := range tests {
+		if err := checkMediaType(tt.header); (err != nil) != tt.wantErr {
+			t.Errorf("checkMediaType(%q) = %v, want error %t", tt.header, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	webhook := newTestWebhook(t, emptyZoneAPI())
+	tests := []struct {
+		name       string
+		method     string
+		accept     string
+		wantStatus int
+	}{
+		{"webhook media type", http.MethodGet, mediaTypeFormatAndVersion, http.StatusOK},
+		{"missing version", http.MethodGet, mediaTypeFormat, http.StatusNotAcceptable},
+		{"version 2", http.MethodGet, mediaTypeFormat + ";version=2", http.StatusNotAcceptable},
+		{"plain json", http.MethodGet, "application/json", http.StatusOK},
+		{"no accept header", http.MethodGet, "", http.StatusOK},
+		{"unsupported type", http.MethodGet, "text/html", http.StatusNotAcceptable},
+		{"post", http.MethodPost, mediaTypeFormatAndVersion, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set(acceptHeader, tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			webhook.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get(contentTypeHeader); got != mediaTypeFormatAndVersion {
+				t.Errorf("Content-Type = %q, want %q", got, mediaTypeFormatAndVersion)
+			}
+			if got := rec.Header().Get(varyHeader); got != contentTypeHeader {
+				t.Errorf("Vary = %q, want %q", got, contentTypeHeader)
+			}
+			var filter endpoint.DomainFilter
+			if err := json.Unmarshal(rec.Body.Bytes(), &filter); err != nil {
+				t.Fatalf("decode domain filter %q: %v", rec.Body.String(), err)
+			}
+			if !slices.Equal(filter.Filters, []string{"example.ee"}) {
+				t.Errorf("domain filter = %q, want [example.ee]", filter.Filters)
+			}
+		})
+	}
+}
+
+func TestRecordsRejectsUnsupportedContentType(t *testing.T) {
+	webhook := newTestWebhook(t, emptyZoneAPI())
+	req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(`{}`))
+	req.Header.Set(contentTypeHeader, mediaTypeFormat+";version=2")
+	rec := httptest.NewRecorder()
+	webhook.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415; body: %s", rec.Code, rec.Body.String())
+	}
+}
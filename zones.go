@@ -0,0 +1,149 @@
+// Fail: zones.go
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// DefaultZoneRefreshInterval määrab, kui tihti konto tsoonide nimekiri uuesti loetakse
+const DefaultZoneRefreshInterval = time.Hour
+
+// zoneList hoiab Zone.ee kontolt leitud ja domeenifiltriga ristatud tsoonide nimekirja.
+// Nimekiri loetakse uuesti, kui see on vanem kui refreshInterval, nii jõuavad uued domeenid
+// webhooki ilma ümberpaigalduseta.
+type zoneList struct {
+	client          *ZoneClient
+	domainFilter    endpoint.DomainFilter
+	refreshInterval time.Duration
+
+	mu        sync.Mutex
+	zones     []string
+	fetchedAt time.Time
+}
+
+// newZoneList loob tsoonide nimekirja, refreshInterval <= 0 korral kasutatakse vaikeväärtust
+func newZoneList(client *ZoneClient, domainFilter endpoint.DomainFilter, refreshInterval time.Duration) *zoneList {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultZoneRefreshInterval
+	}
+	return &zoneList{client: client, domainFilter: domainFilter, refreshInterval: refreshInterval}
+}
+
+// Zones tagastab hallatavad tsoonid sorteeritult. Kui nimekiri on aegunud, loetakse see kontolt uuesti;
+// lugemise ebaõnnestumisel kasutatakse eelmist nimekirja, kui see on olemas.
+func (l *zoneList) Zones(ctx context.Context) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.zones != nil && time.Since(l.fetchedAt) < l.refreshInterval {
+		return l.zones, nil
+	}
+
+	zones, err := l.refreshLocked(ctx)
+	if err != nil {
+		if l.zones != nil {
+			logFrom(ctx).Warn("Failed to refresh zone list, using previous list", "zones", len(l.zones), logKeyError, err)
+			return l.zones, nil
+		}
+		return nil, err
+	}
+	return zones, nil
+}
+
+// Discover loeb konto tsoonid ja ristab need filtriga, aga ei asenda vahemälus olevat nimekirja
+// ega nihuta selle uuendamise aega. Erinevalt Zones'ist tagastatakse viga ka siis, kui eelmine
+// nimekiri on olemas, nii et kutsuja näeb nt tühistatud API võtit.
+func (l *zoneList) Discover(ctx context.Context) ([]string, error) {
+	zones, _, err := l.discover(ctx)
+	return zones, err
+}
+
+// discover loeb konto tsoonid ja tagastab filtrile vastavad sorteeritult koos konto tsoonide arvuga
+func (l *zoneList) discover(ctx context.Context) ([]string, int, error) {
+	accountZones, err := l.client.ListZones(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	zones := []string{}
+	for _, zone := range accountZones {
+		if l.managesZone(zone) {
+			zones = append(zones, zone)
+		}
+	}
+	slices.Sort(zones)
+	return slices.Compact(zones), len(accountZones), nil
+}
+
+// refreshLocked loeb tsoonid kontolt ja salvestab need nimekirja, l.mu peab olema lukustatud
+func (l *zoneList) refreshLocked(ctx context.Context) ([]string, error) {
+	zones, accountZones, err := l.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Equal(zones, l.zones) {
+		logFrom(ctx).Info("Managed zones changed", "zones", zones, "account_zones", accountZones)
+		for _, zone := range l.zones {
+			if !slices.Contains(zones, zone) {
+				zoneRecordsGauge.DeleteLabelValues(zone)
+			}
+		}
+	}
+	l.zones = zones
+	l.fetchedAt = time.Now()
+	return zones, nil
+}
+
+// managesZone otsustab, kas konto tsooniga tuleb suhelda. Tsoon sobib, kui see vastab filtrile
+// või kui mõni filter on selle tsooni alamdomeen (nt filter team-a.example.ee tsoonis example.ee).
+// Viimasel juhul piiratakse nähtavad ja muudetavad nimed provideris filtri alla.
+// Regex filtri korral alamdomeene ei tuletata, sest regexist ei saa tsooni nime järeldada.
+func (l *zoneList) managesZone(zone string) bool {
+	if l.domainFilter.Match(zone) {
+		return true
+	}
+	if len(l.domainFilter.Filters) == 0 {
+		return false
+	}
+	if l.domainFilter.MatchParent(zone) {
+		return true
+	}
+	// MatchParent jätab punktiga algavad filtrid (ainult alamdomeenid, nt .example.ee) vahele,
+	// aga ka need nimed elavad tsoonis example.ee
+	for _, filter := range l.domainFilter.Filters {
+		if strings.HasPrefix(filter, ".") && (filter == "."+zone || strings.HasSuffix(filter, "."+zone)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmatchedFilters tagastab domeenifiltri sufiksid, millele ei vasta ükski hallatav tsoon
+// (nt kirjaviga filtris või domeen, mida Zone.ee kontol pole). Regex filtrit ei kontrollita.
+func (l *zoneList) unmatchedFilters(zones []string) []string {
+	var unmatched []string
+	for _, filter := range l.domainFilter.Filters {
+		name := strings.ToLower(strings.Trim(filter, "."))
+		if name == "" {
+			continue
+		}
+		matched := slices.ContainsFunc(zones, func(zone string) bool {
+			return zone == name || strings.HasSuffix(zone, "."+name) || strings.HasSuffix(name, "."+zone)
+		})
+		if !matched {
+			unmatched = append(unmatched, filter)
+		}
+	}
+	return unmatched
+}
+
+// errNoZones tagastatakse, kui domeenifiltrile ei vasta ükski konto tsoon
+var errNoZones = errors.New("no Zone.ee zones on the account match the domain filter")
@@ -0,0 +1,170 @@
+// Fail: zones_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestManagesZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter endpoint.DomainFilter
+		zone   string
+		want   bool
+	}{
+		{"no filter manages every zone", endpoint.NewDomainFilter(nil), "example.ee", true},
+		{"suffix filter matches zone", endpoint.NewDomainFilter([]string{"example.ee"}), "example.ee", true},
+		{"suffix filter matches sub-zone", endpoint.NewDomainFilter([]string{"example.ee"}), "sub.example.ee", true},
+		{"suffix filter ignores other zone", endpoint.NewDomainFilter([]string{"example.ee"}), "other.ee", false},
+		{"suffix filter ignores look-alike zone", endpoint.NewDomainFilter([]string{"example.ee"}), "notexample.ee", false},
+		{"filter with trailing dot", endpoint.NewDomainFilter([]string{"example.ee."}), "example.ee", true},
+		{"subdomain filter matches parent zone", endpoint.NewDomainFilter([]string{"team-a.example.ee"}), "example.ee", true},
+		{"subdomain filter ignores sibling zone", endpoint.NewDomainFilter([]string{"team-a.example.ee"}), "team-b.example.ee", false},
+		{"dot-prefixed filter matches parent zone", endpoint.NewDomainFilter([]string{".example.ee"}), "example.ee", true},
+		{"dot-prefixed subdomain filter matches zone", endpoint.NewDomainFilter([]string{".team-a.example.ee"}), "example.ee", true},
+		{"dot-prefixed filter ignores other zone", endpoint.NewDomainFilter([]string{".example.ee"}), "other.ee", false},
+		{"excluded sub-zone", endpoint.NewDomainFilterWithExclusions([]string{"example.ee"}, []string{"internal.example.ee"}), "internal.example.ee", false},
+		{"exclusion keeps parent zone", endpoint.NewDomainFilterWithExclusions([]string{"example.ee"}, []string{"internal.example.ee"}), "example.ee", true},
+		{"regex filter matches zone", endpoint.NewRegexDomainFilter(regexp.MustCompile(`^example\.ee$`), nil), "example.ee", true},
+		{"regex filter does not derive parent zones", endpoint.NewRegexDomainFilter(regexp.MustCompile(`^a\.example\.ee$`), nil), "example.ee", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newZoneList(nil, tt.filter, 0)
+			if got := l.managesZone(tt.zone); got != tt.want {
+				t.Errorf("managesZone(%q) with %+v = %t, want %t", tt.zone, tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmatchedFilters(t *testing.T) {
+	tests := []struct {
+		filters []string
+		zones   []string
+		want    []string
+	}{
+		{[]string{"example.ee"}, []string{"example.ee"}, nil},
+		{[]string{"team-a.example.ee"}, []string{"example.ee"}, nil},
+		{[]string{".example.ee"}, []string{"example.ee"}, nil},
+		{[]string{"example.ee"}, []string{"sub.example.ee"}, nil},
+		{[]string{"example.ee", "exmaple.ee"}, []string{"example.ee"}, []string{"exmaple.ee"}},
+		{[]string{"other.ee"}, nil, []string{"other.ee"}},
+		{nil, []string{"example.ee"}, nil},
+	}
+	for _, tt := range tests {
+		l := newZoneList(nil, endpoint.NewDomainFilter(tt.filters), 0)
+		if got := l.unmatchedFilters(tt.zones); !slices.Equal(got, tt.want) {
+			t.Errorf("unmatchedFilters(%q) with filters %q = %q, want %q", tt.zones, tt.filters, got, tt.want)
+		}
+	}
+}
+
+// accountZonesAPI on Zone.ee asendus, mille kontol on antud tsoonid ja tühjad kirjete listingud
+func accountZonesAPI(zones ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dns" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		names := make([]string, len(zones))
+		for i, zone := range zones {
+			names[i] = `{"name":"` + zone + `"}`
+		}
+		w.Write([]byte("[" + strings.Join(names, ",") + "]"))
+	})
+}
+
+func TestZoneDiscovery(t *testing.T) {
+	api := accountZonesAPI("other.ee", "example.ee", "sub.example.ee", "internal.example.ee", "notexample.ee")
+	tests := []struct {
+		name   string
+		filter endpoint.DomainFilter
+		want   []string
+	}{
+		{"suffix filter", endpoint.NewDomainFilter([]string{"example.ee"}), []string{"example.ee", "internal.example.ee", "sub.example.ee"}},
+		{"exclusion", endpoint.NewDomainFilterWithExclusions([]string{"example.ee"}, []string{"internal.example.ee"}), []string{"example.ee", "sub.example.ee"}},
+		{"parent zone of a subdomain filter", endpoint.NewDomainFilter([]string{"team-a.example.ee"}), []string{"example.ee"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, api, ZoneProviderConfig{DomainFilter: tt.filter})
+			zones, err := provider.zones.Zones(context.Background())
+			if err != nil {
+				t.Fatalf("Zones: %v", err)
+			}
+			if !slices.Equal(zones, tt.want) {
+				t.Errorf("zones = %q, want %q", zones, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAccessReportsUnmatchedFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []string
+		wantErr string
+	}{
+		{"typo next to a valid filter", []string{"example.ee", "exmaple.ee"}, "domain filter [exmaple.ee] matches no zone"},
+		{"no zone matches", []string{"missing.ee"}, errNoZones.Error()},
+		{"every filter matches", []string{"example.ee", "team-a.example.ee"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, accountZonesAPI("example.ee", "other.ee"),
+				ZoneProviderConfig{DomainFilter: endpoint.NewDomainFilter(tt.filters)})
+			err := provider.CheckAccess(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckAccess: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckAccess = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAccessKeepsCachedZoneList(t *testing.T) {
+	var accountZones atomic.Pointer[http.Handler]
+	initial := accountZonesAPI("example.ee")
+	accountZones.Store(&initial)
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*accountZones.Load()).ServeHTTP(w, r)
+	})
+	provider := newTestProvider(t, api, ZoneProviderConfig{})
+
+	ctx := context.Background()
+	if _, err := provider.zones.Zones(ctx); err != nil {
+		t.Fatalf("Zones: %v", err)
+	}
+	fetchedAt := provider.zones.fetchedAt
+
+	// Kontole lisandub tsoon; valmisoleku kontroll näeb seda, aga vahemälus olev nimekiri ei muutu
+	grown := accountZonesAPI("example.ee", "sub.example.ee")
+	accountZones.Store(&grown)
+	if err := provider.CheckAccess(ctx); err != nil {
+		t.Fatalf("CheckAccess: %v", err)
+	}
+	zones, err := provider.zones.Zones(ctx)
+	if err != nil {
+		t.Fatalf("Zones: %v", err)
+	}
+	if want := []string{"example.ee"}; !slices.Equal(zones, want) {
+		t.Errorf("cached zones after CheckAccess = %q, want %q", zones, want)
+	}
+	if !provider.zones.fetchedAt.Equal(fetchedAt) {
+		t.Errorf("CheckAccess moved fetchedAt from %s to %s", fetchedAt, provider.zones.fetchedAt)
+	}
+}